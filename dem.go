@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/tiff/lzw"
+)
+
+// Digital Elevation Model
+//
+// Phone elevations are unreliable, so tracks can be corrected against local
+// DEM tiles. Two formats are supported, both in WGS84 lat/lon:
+//   - SRTM .hgt tiles named after their south-west corner (N44E009.hgt)
+//   - GeoTIFF rasters (single band, 16/32-bit, uncompressed, Deflate or LZW)
+
+const (
+	demModeFill    = "fill"    // only set missing elevations
+	demModeReplace = "replace" // overwrite every elevation
+)
+
+type DEM struct {
+	dir      string
+	hgt      map[string]*demGrid // lazily loaded, nil when the tile is missing
+	geotiffs []*demGrid
+}
+
+// demGrid is a georeferenced raster. Sample (row, col) is located at
+// (OriginLat - row*StepLat, OriginLon + col*StepLon).
+type demGrid struct {
+	OriginLat float64
+	OriginLon float64
+	StepLat   float64
+	StepLon   float64
+	Width     int
+	Height    int
+	Data      []float64
+	NoData    float64
+}
+
+// loadDEM indexes the tiles in dir. HGT tiles are read on first use since
+// they are large; GeoTIFFs are read upfront to know their extent.
+func loadDEM(dir string) (*DEM, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	dem := &DEM{dir: dir, hgt: make(map[string]*demGrid)}
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".tif" && ext != ".tiff") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		grid, err := loadGeoTiff(path)
+		if err != nil {
+			log.Printf("Warning: skipping DEM %s: %v", path, err)
+			continue
+		}
+		dem.geotiffs = append(dem.geotiffs, grid)
+	}
+	return dem, nil
+}
+
+// Elevation returns the terrain elevation in meters at the given position.
+func (d *DEM) Elevation(lat, lon float64) (float64, bool) {
+	if grid := d.hgtTile(lat, lon); grid != nil {
+		if ele, ok := grid.sample(lat, lon); ok {
+			return ele, true
+		}
+	}
+	for _, grid := range d.geotiffs {
+		if ele, ok := grid.sample(lat, lon); ok {
+			return ele, true
+		}
+	}
+	return 0, false
+}
+
+func (d *DEM) hgtTile(lat, lon float64) *demGrid {
+	latF, lonF := int(math.Floor(lat)), int(math.Floor(lon))
+	ns, ew := 'N', 'E'
+	if latF < 0 {
+		ns = 'S'
+	}
+	if lonF < 0 {
+		ew = 'W'
+	}
+	name := fmt.Sprintf("%c%02d%c%03d.hgt", ns, abs(latF), ew, abs(lonF))
+
+	if grid, ok := d.hgt[name]; ok {
+		return grid
+	}
+	grid, err := loadHgt(filepath.Join(d.dir, name), latF, lonF)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: failed to load DEM tile %s: %v", name, err)
+	}
+	d.hgt[name] = grid // cache misses too
+	return grid
+}
+
+// loadHgt reads an SRTM tile: a square grid of big-endian int16 samples,
+// north to south, covering one degree with overlapping edges.
+func loadHgt(path string, latF, lonF int) (*demGrid, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	size := int(math.Sqrt(float64(len(data) / 2)))
+	if size < 2 || size*size*2 != len(data) {
+		return nil, fmt.Errorf("unexpected HGT size %d bytes", len(data))
+	}
+
+	grid := &demGrid{
+		OriginLat: float64(latF + 1),
+		OriginLon: float64(lonF),
+		StepLat:   1 / float64(size-1),
+		StepLon:   1 / float64(size-1),
+		Width:     size,
+		Height:    size,
+		Data:      make([]float64, size*size),
+		NoData:    -32768,
+	}
+	for i := range grid.Data {
+		grid.Data[i] = float64(int16(binary.BigEndian.Uint16(data[i*2:])))
+	}
+	return grid, nil
+}
+
+// sample interpolates bilinearly between the four surrounding samples.
+func (g *demGrid) sample(lat, lon float64) (float64, bool) {
+	x := (lon - g.OriginLon) / g.StepLon
+	y := (g.OriginLat - lat) / g.StepLat
+	// Rounding can put a point on the edge just outside the grid
+	const eps = 1e-9
+	if x < -eps || y < -eps || x > float64(g.Width-1)+eps || y > float64(g.Height-1)+eps {
+		return 0, false
+	}
+	x = min(max(x, 0), float64(g.Width-1))
+	y = min(max(y, 0), float64(g.Height-1))
+
+	// Neighbors with no weight are not read, so a sample next to a void is
+	// still valid
+	x0, y0 := int(x), int(y)
+	fx, fy := x-float64(x0), y-float64(y0)
+	x1, y1 := x0, y0
+	if fx > 0 {
+		x1 = x0 + 1
+	}
+	if fy > 0 {
+		y1 = y0 + 1
+	}
+
+	v00 := g.Data[y0*g.Width+x0]
+	v10 := g.Data[y0*g.Width+x1]
+	v01 := g.Data[y1*g.Width+x0]
+	v11 := g.Data[y1*g.Width+x1]
+	for _, v := range []float64{v00, v10, v01, v11} {
+		if v == g.NoData || math.IsNaN(v) {
+			return 0, false
+		}
+	}
+
+	top := v00*(1-fx) + v10*fx
+	bottom := v01*(1-fx) + v11*fx
+	return top*(1-fy) + bottom*fy, true
+}
+
+// correctElevations samples the DEM for the track points. In fill mode only
+// points without elevation are touched. Returns the number of points updated.
+func correctElevations(t *Track, dem *DEM, mode string) int {
+	n := 0
+	for _, seg := range t.Segments {
+		for i := range seg {
+			if mode != demModeReplace && seg[i].HasEle {
+				continue
+			}
+			if ele, ok := dem.Elevation(seg[i].Lat, seg[i].Lon); ok {
+				seg[i].Ele = ele
+				seg[i].HasEle = true
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// GeoTIFF
+
+const (
+	tiffImageWidth      = 256
+	tiffImageLength     = 257
+	tiffBitsPerSample   = 258
+	tiffCompression     = 259
+	tiffStripOffsets    = 273
+	tiffSamplesPerPixel = 277
+	tiffRowsPerStrip    = 278
+	tiffStripByteCounts = 279
+	tiffPredictor       = 317
+	tiffTileWidth       = 322
+	tiffTileLength      = 323
+	tiffTileOffsets     = 324
+	tiffTileByteCounts  = 325
+	tiffSampleFormat    = 339
+	geoPixelScale       = 33550
+	geoTiepoint         = 33922
+	geoKeyDirectory     = 34735
+	gdalNoData          = 42113
+
+	geoKeyModelType  = 1024
+	geoKeyRasterType = 1025
+)
+
+type tiffEntry struct {
+	Type  uint16
+	Count uint32
+	Raw   []byte // value bytes, already resolved from the offset
+}
+
+func loadGeoTiff(path string) (*demGrid, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 {
+		return nil, fmt.Errorf("file too short")
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("not a TIFF (BigTIFF is not supported)")
+	}
+
+	entries, err := readTiffIFD(data, order, order.Uint32(data[4:8]))
+	if err != nil {
+		return nil, err
+	}
+	num := func(tag uint16, def float64) float64 {
+		if v := tiffValues(entries[tag], order); len(v) > 0 {
+			return v[0]
+		}
+		return def
+	}
+
+	width, height := int(num(tiffImageWidth, 0)), int(num(tiffImageLength, 0))
+	bits := int(num(tiffBitsPerSample, 16))
+	format := int(num(tiffSampleFormat, 1))
+	compression := int(num(tiffCompression, 1))
+	predictor := int(num(tiffPredictor, 1))
+
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("missing image size")
+	}
+	if int(num(tiffSamplesPerPixel, 1)) != 1 {
+		return nil, fmt.Errorf("only single band rasters are supported")
+	}
+	if bits != 16 && bits != 32 {
+		return nil, fmt.Errorf("unsupported %d bits per sample", bits)
+	}
+	if predictor != 1 && (predictor != 2 || format == 3) {
+		return nil, fmt.Errorf("unsupported predictor %d", predictor)
+	}
+
+	// Georeferencing: only geographic lat/lon rasters
+	scale := tiffValues(entries[geoPixelScale], order)
+	tie := tiffValues(entries[geoTiepoint], order)
+	if len(scale) < 2 || len(tie) < 6 {
+		return nil, fmt.Errorf("missing GeoTIFF georeferencing tags")
+	}
+	keys := geoKeys(tiffValues(entries[geoKeyDirectory], order))
+	if mt, ok := keys[geoKeyModelType]; ok && mt != 2 {
+		return nil, fmt.Errorf("only geographic (EPSG:4326) rasters are supported")
+	}
+
+	grid := &demGrid{
+		StepLon: scale[0],
+		StepLat: scale[1],
+		Width:   width,
+		Height:  height,
+		Data:    make([]float64, width*height),
+		NoData:  math.NaN(),
+	}
+	// Tiepoint maps raster (I, J) to (lon, lat). With PixelIsArea (the
+	// default) it refers to the pixel corner, samples are at pixel centers.
+	grid.OriginLon = tie[3] - tie[0]*scale[0]
+	grid.OriginLat = tie[4] + tie[1]*scale[1]
+	if keys[geoKeyRasterType] != 2 {
+		grid.OriginLon += scale[0] / 2
+		grid.OriginLat -= scale[1] / 2
+	}
+	if e, ok := entries[gdalNoData]; ok {
+		if v, err := strconv.ParseFloat(strings.Trim(string(e.Raw), "\x00 "), 64); err == nil {
+			grid.NoData = v
+		}
+	}
+
+	// Raster layout: strips are tiles as wide as the image
+	blockW, blockH := width, int(num(tiffRowsPerStrip, float64(height)))
+	offsets := tiffValues(entries[tiffStripOffsets], order)
+	counts := tiffValues(entries[tiffStripByteCounts], order)
+	if _, tiled := entries[tiffTileOffsets]; tiled {
+		blockW, blockH = int(num(tiffTileWidth, 0)), int(num(tiffTileLength, 0))
+		offsets = tiffValues(entries[tiffTileOffsets], order)
+		counts = tiffValues(entries[tiffTileByteCounts], order)
+	}
+	if blockW == 0 || blockH == 0 || len(offsets) != len(counts) {
+		return nil, fmt.Errorf("invalid raster layout")
+	}
+	blocksAcross := (width + blockW - 1) / blockW
+	bytesPerSample := bits / 8
+
+	for i := range offsets {
+		start, end := int(offsets[i]), int(offsets[i])+int(counts[i])
+		if end > len(data) {
+			return nil, fmt.Errorf("block %d out of range", i)
+		}
+		block, err := tiffDecompress(data[start:end], compression)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", i, err)
+		}
+
+		bx, by := (i%blocksAcross)*blockW, (i/blocksAcross)*blockH
+		rows := min(blockH, len(block)/(blockW*bytesPerSample)) // last strip may be shorter
+		for row := 0; row < rows; row++ {
+			var acc int64
+			for col := 0; col < blockW; col++ {
+				o := (row*blockW + col) * bytesPerSample
+				v, raw := tiffSample(block[o:o+bytesPerSample], order, bits, format)
+				if predictor == 2 {
+					// Horizontal differencing: samples are deltas from the left one
+					acc += raw
+					v = tiffWrap(acc, bits, format)
+				}
+				x, y := bx+col, by+row
+				if x < width && y < height {
+					grid.Data[y*width+x] = v
+				}
+			}
+		}
+	}
+
+	return grid, nil
+}
+
+func readTiffIFD(data []byte, order binary.ByteOrder, offset uint32) (map[uint16]tiffEntry, error) {
+	if int(offset)+2 > len(data) {
+		return nil, fmt.Errorf("IFD out of range")
+	}
+	n := int(order.Uint16(data[offset:]))
+	pos := int(offset) + 2
+	if pos+n*12 > len(data) {
+		return nil, fmt.Errorf("IFD out of range")
+	}
+
+	typeSizes := map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 8: 2, 9: 4, 11: 4, 12: 8}
+
+	entries := make(map[uint16]tiffEntry, n)
+	for i := 0; i < n; i++ {
+		e := data[pos+i*12 : pos+(i+1)*12]
+		tag, typ, count := order.Uint16(e[0:]), order.Uint16(e[2:]), order.Uint32(e[4:])
+		size, ok := typeSizes[typ]
+		if !ok {
+			continue
+		}
+		total := size * int(count)
+		raw := e[8:12]
+		if total > 4 {
+			off := int(order.Uint32(e[8:]))
+			if off+total > len(data) {
+				return nil, fmt.Errorf("tag %d out of range", tag)
+			}
+			raw = data[off : off+total]
+		}
+		entries[tag] = tiffEntry{Type: typ, Count: count, Raw: raw[:min(total, len(raw))]}
+	}
+	return entries, nil
+}
+
+// tiffValues decodes numeric tag values as float64.
+func tiffValues(e tiffEntry, order binary.ByteOrder) []float64 {
+	var out []float64
+	for i := 0; i < int(e.Count); i++ {
+		switch e.Type {
+		case 1:
+			out = append(out, float64(e.Raw[i]))
+		case 3:
+			out = append(out, float64(order.Uint16(e.Raw[i*2:])))
+		case 8:
+			out = append(out, float64(int16(order.Uint16(e.Raw[i*2:]))))
+		case 4:
+			out = append(out, float64(order.Uint32(e.Raw[i*4:])))
+		case 9:
+			out = append(out, float64(int32(order.Uint32(e.Raw[i*4:]))))
+		case 11:
+			out = append(out, float64(math.Float32frombits(order.Uint32(e.Raw[i*4:]))))
+		case 12:
+			out = append(out, math.Float64frombits(order.Uint64(e.Raw[i*8:])))
+		default:
+			return out
+		}
+	}
+	return out
+}
+
+// geoKeys parses the GeoKeyDirectory into key -> short value.
+func geoKeys(dir []float64) map[int]int {
+	keys := make(map[int]int)
+	if len(dir) < 4 {
+		return keys
+	}
+	for i := 0; i < int(dir[3]) && 4+i*4+3 < len(dir); i++ {
+		k := dir[4+i*4:]
+		if k[1] == 0 { // value stored inline
+			keys[int(k[0])] = int(k[3])
+		}
+	}
+	return keys
+}
+
+func tiffDecompress(b []byte, compression int) ([]byte, error) {
+	switch compression {
+	case 1:
+		return b, nil
+	case 8, 32946:
+		r, err := zlib.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case 5:
+		r := lzw.NewReader(bytes.NewReader(b), lzw.MSB, 8)
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+	return nil, fmt.Errorf("unsupported compression %d", compression)
+}
+
+// tiffSample decodes one sample, returning it as float and as the raw
+// integer (used to undo the horizontal predictor).
+func tiffSample(b []byte, order binary.ByteOrder, bits, format int) (float64, int64) {
+	switch {
+	case bits == 16 && format == 2:
+		v := int16(order.Uint16(b))
+		return float64(v), int64(v)
+	case bits == 16:
+		v := order.Uint16(b)
+		return float64(v), int64(v)
+	case bits == 32 && format == 3:
+		return float64(math.Float32frombits(order.Uint32(b))), 0
+	case bits == 32 && format == 2:
+		v := int32(order.Uint32(b))
+		return float64(v), int64(v)
+	default:
+		v := order.Uint32(b)
+		return float64(v), int64(v)
+	}
+}
+
+// tiffWrap truncates an accumulated integer to the sample type.
+func tiffWrap(v int64, bits, format int) float64 {
+	switch {
+	case bits == 16 && format == 2:
+		return float64(int16(v))
+	case bits == 16:
+		return float64(uint16(v))
+	case format == 2:
+		return float64(int32(v))
+	default:
+		return float64(uint32(v))
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
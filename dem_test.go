@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"compress/lzw"
+	"compress/zlib"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHgtElevation(t *testing.T) {
+	// 3x3 samples over N44E009, 0.5° apart, north row first
+	samples := []int16{
+		1000, 1010, 1020,
+		1100, 1110, -32768,
+		1200, 1210, 1220,
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, samples)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "N44E009.hgt"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dem, err := loadDEM(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		lat, lon float64
+		want     float64
+		ok       bool
+	}{
+		{44.9999999999, 9, 1000, true}, // North-west corner, 45 is in N45E009.hgt
+		{44.5, 9.5, 1110, true},        // Center
+		{44, 9, 1200, true},            // South-west corner
+		{44.75, 9.25, 1055, true},      // Between four samples
+		{44.5, 9.75, 0, false},         // Next to the void
+		{43.5, 9.5, 0, false},          // N43E009.hgt is missing
+		{44.5, 10.5, 0, false},         // N44E010.hgt is missing
+	} {
+		got, ok := dem.Elevation(c.lat, c.lon)
+		if ok != c.ok || math.Abs(got-c.want) > 1e-6 {
+			t.Errorf("Elevation(%v, %v) = %v, %v, want %v, %v", c.lat, c.lon, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+// geoTiffFixture describes a 4x3 int16 raster over lon 9.00-9.04, lat
+// 44.97-45.00 (0.01° pixels), value 1000 + 10*row + col.
+type geoTiffFixture struct {
+	order        binary.ByteOrder
+	compression  int
+	predictor    int
+	rowsPerStrip int // Stripped layout when > 0
+	tileSize     int // Tiled layout when > 0
+}
+
+const fixtureW, fixtureH = 4, 3
+
+func fixtureValue(row, col int) int16 { return int16(1000 + 10*row + col) }
+
+func (f geoTiffFixture) encode(t *testing.T) []byte {
+	t.Helper()
+	blockW, blockH := fixtureW, f.rowsPerStrip
+	if f.tileSize > 0 {
+		blockW, blockH = f.tileSize, f.tileSize
+	}
+	across := (fixtureW + blockW - 1) / blockW
+	down := (fixtureH + blockH - 1) / blockH
+
+	var blocks [][]byte
+	for by := 0; by < down; by++ {
+		for bx := 0; bx < across; bx++ {
+			var raw bytes.Buffer
+			for row := by * blockH; row < (by+1)*blockH; row++ {
+				if f.tileSize == 0 && row >= fixtureH {
+					break // The last strip is shorter, tiles are padded
+				}
+				prev := int16(0)
+				for col := bx * blockW; col < (bx+1)*blockW; col++ {
+					v := int16(0)
+					if row < fixtureH && col < fixtureW {
+						v = fixtureValue(row, col)
+					}
+					if f.predictor == 2 {
+						v, prev = v-prev, v
+					}
+					binary.Write(&raw, f.order, v)
+				}
+			}
+			blocks = append(blocks, compressTiffBlock(t, raw.Bytes(), f.compression))
+		}
+	}
+
+	// Header, blocks, then the IFD with its out-of-line values
+	var out bytes.Buffer
+	if f.order == binary.LittleEndian {
+		out.WriteString("II*\x00")
+	} else {
+		out.WriteString("MM\x00*")
+	}
+	binary.Write(&out, f.order, uint32(0)) // IFD offset, patched below
+	var offsets, counts []uint32
+	for _, b := range blocks {
+		offsets = append(offsets, uint32(out.Len()))
+		counts = append(counts, uint32(len(b)))
+		out.Write(b)
+	}
+
+	type tag struct {
+		id, typ uint16
+		count   int
+		value   []byte
+	}
+	enc := func(v any) []byte {
+		var b bytes.Buffer
+		binary.Write(&b, f.order, v)
+		return b.Bytes()
+	}
+	short := func(id uint16, v ...uint16) tag { return tag{id, 3, len(v), enc(v)} }
+	long := func(id uint16, v ...uint32) tag { return tag{id, 4, len(v), enc(v)} }
+	double := func(id uint16, v ...float64) tag { return tag{id, 12, len(v), enc(v)} }
+
+	tags := []tag{
+		short(tiffImageWidth, fixtureW),
+		short(tiffImageLength, fixtureH),
+		short(tiffBitsPerSample, 16),
+		short(tiffCompression, uint16(f.compression)),
+		short(tiffSamplesPerPixel, 1),
+		short(tiffPredictor, uint16(f.predictor)),
+		short(tiffSampleFormat, 2),
+		double(geoPixelScale, 0.01, 0.01, 0),
+		double(geoTiepoint, 0, 0, 0, 9, 45, 0),
+		// Version 1.1.0, 2 keys: geographic model, PixelIsArea
+		short(geoKeyDirectory, 1, 1, 0, 2, geoKeyModelType, 0, 1, 2, geoKeyRasterType, 0, 1, 1),
+		{gdalNoData, 2, 7, []byte("-32768\x00")},
+	}
+	if f.tileSize > 0 {
+		tags = append(tags,
+			short(tiffTileWidth, uint16(f.tileSize)),
+			short(tiffTileLength, uint16(f.tileSize)),
+			long(tiffTileOffsets, offsets...),
+			long(tiffTileByteCounts, counts...))
+	} else {
+		tags = append(tags,
+			long(tiffStripOffsets, offsets...),
+			short(tiffRowsPerStrip, uint16(f.rowsPerStrip)),
+			long(tiffStripByteCounts, counts...))
+	}
+
+	// Values that do not fit in 4 bytes go after the IFD
+	ifd := out.Len()
+	extra := ifd + 2 + len(tags)*12 + 4
+	var values bytes.Buffer
+	binary.Write(&out, f.order, uint16(len(tags)))
+	for _, tg := range tags {
+		binary.Write(&out, f.order, tg.id)
+		binary.Write(&out, f.order, tg.typ)
+		binary.Write(&out, f.order, uint32(tg.count))
+		if len(tg.value) <= 4 {
+			out.Write(append(tg.value, make([]byte, 4-len(tg.value))...))
+		} else {
+			binary.Write(&out, f.order, uint32(extra+values.Len()))
+			values.Write(tg.value)
+		}
+	}
+	binary.Write(&out, f.order, uint32(0)) // No next IFD
+	out.Write(values.Bytes())
+
+	b := out.Bytes()
+	f.order.PutUint32(b[4:], uint32(ifd))
+	return b
+}
+
+// compressTiffBlock compresses a block. The fixture blocks are small enough
+// that the LZW codes never grow past 9 bits, where TIFF's "early change"
+// makes no difference to compress/lzw.
+func compressTiffBlock(t *testing.T, raw []byte, compression int) []byte {
+	var b bytes.Buffer
+	switch compression {
+	case 1:
+		return raw
+	case 5:
+		w := lzw.NewWriter(&b, lzw.MSB, 8)
+		w.Write(raw)
+		w.Close()
+	case 8:
+		w := zlib.NewWriter(&b)
+		w.Write(raw)
+		w.Close()
+	default:
+		t.Fatalf("unsupported compression %d", compression)
+	}
+	return b.Bytes()
+}
+
+func TestGeoTiffElevation(t *testing.T) {
+	for name, f := range map[string]geoTiffFixture{
+		"strips":             {order: binary.LittleEndian, compression: 1, predictor: 1, rowsPerStrip: 2},
+		"strips lzw pred":    {order: binary.LittleEndian, compression: 5, predictor: 2, rowsPerStrip: 1},
+		"tiles deflate":      {order: binary.LittleEndian, compression: 8, predictor: 1, tileSize: 16},
+		"tiles deflate pred": {order: binary.BigEndian, compression: 8, predictor: 2, tileSize: 16},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dem.tif")
+			if err := os.WriteFile(path, f.encode(t), 0644); err != nil {
+				t.Fatal(err)
+			}
+			dem, err := loadDEM(filepath.Dir(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(dem.geotiffs) != 1 {
+				t.Fatalf("loaded %d GeoTIFFs, want 1", len(dem.geotiffs))
+			}
+
+			// Samples are at pixel centers
+			for row := 0; row < fixtureH; row++ {
+				for col := 0; col < fixtureW; col++ {
+					lat, lon := 45-0.005-float64(row)*0.01, 9+0.005+float64(col)*0.01
+					got, ok := dem.Elevation(lat, lon)
+					if want := float64(fixtureValue(row, col)); !ok || math.Abs(got-want) > 1e-6 {
+						t.Errorf("pixel (%d, %d): Elevation = %v, %v, want %v", row, col, got, ok, want)
+					}
+				}
+			}
+			if _, ok := dem.Elevation(45.5, 9.02); ok {
+				t.Error("Elevation north of the raster: ok = true")
+			}
+			if _, ok := dem.Elevation(44.98, 9.1); ok {
+				t.Error("Elevation east of the raster: ok = true")
+			}
+		})
+	}
+}
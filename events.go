@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Events Calendar
+//
+// Each event lives in content/events/<slug>.toml with real start/end times
+// (local to the site timezone) and per-locale text. Dates are formatted per
+// locale at build time and past events are not rendered.
+
+type EventFile struct {
+	Slug      string         `toml:"slug"`
+	Category  string         `toml:"category"` // Key into the localized events_page.categories
+	Start     time.Time      `toml:"start"`    // 2026-08-16 or 2026-08-10T15:00:00, site timezone
+	End       time.Time      `toml:"end"`      // Optional
+	AllDay    bool           `toml:"all_day"`
+	Published toml.LocalDate `toml:"published"` // When the event was announced, for the feed
+	It        EventLocale    `toml:"it"`
+	En        EventLocale    `toml:"en"`
+
+	Updated time.Time `toml:"-"` // Last change of the content file
+	Added   time.Time `toml:"-"` // First commit of the content file
+}
+
+type EventLocale struct {
+	Title       string `toml:"title"`
+	Description string `toml:"description"`
+	Location    string `toml:"location"`
+}
+
+type EventsPageLocale struct {
+	Title         string            `toml:"title"`
+	Subtitle      string            `toml:"subtitle"`
+	UpcomingTitle string            `toml:"upcoming_title"`
+	SeeAll        string            `toml:"see_all"`
+	NoEvents      string            `toml:"no_events"`
+	AllDay        string            `toml:"all_day"`
+	AddToCalendar string            `toml:"add_to_calendar"`
+	Subscribe     string            `toml:"subscribe"`
+	Categories    map[string]string `toml:"categories"`
+}
+
+type RenderEvent struct {
+	Slug          string
+	Category      string
+	CategoryLabel string
+	Title         string
+	Description   string
+	Location      string
+	Date          string // Localized, e.g. "16 agosto 2026" or "10 - 12 agosto 2026"
+	Time          string // Localized, empty for all-day events
+	Month         string // Localized month heading, e.g. "Agosto 2026"
+}
+
+func loadEvents(dir string, site *SiteConfig) ([]EventFile, error) {
+	var events []EventFile
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".toml") {
+			return nil
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var ev EventFile
+		if err := toml.Unmarshal(b, &ev); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if ev.Slug == "" {
+			ev.Slug = strings.TrimSuffix(filepath.Base(path), ".toml")
+		}
+		if ev.Start.IsZero() {
+			return fmt.Errorf("%s: missing start", path)
+		}
+
+		// The decoder puts local dates and datetimes in the host's zone: read
+		// them again as written to place them in the site timezone
+		var when struct {
+			Start any `toml:"start"`
+			End   any `toml:"end"`
+		}
+		if err := toml.Unmarshal(b, &when); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		ev.Start = inLocation(when.Start, site.Location)
+		if !ev.End.IsZero() {
+			ev.End = inLocation(when.End, site.Location)
+		}
+
+		ev.Updated = contentModTime(path)
+		ev.Added = contentAddedTime(path)
+
+		events = append(events, ev)
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Start.Before(events[j].Start)
+	})
+	return events, err
+}
+
+// inLocation places a TOML date or datetime in loc: local dates and
+// datetimes carry no zone and are wall clock in loc, offset datetimes
+// (2026-08-15T16:00:00Z) name an instant and are only converted.
+func inLocation(v any, loc *time.Location) time.Time {
+	switch v := v.(type) {
+	case toml.LocalDate:
+		return v.AsTime(loc)
+	case toml.LocalDateTime:
+		return v.AsTime(loc)
+	case time.Time:
+		return v.In(loc)
+	}
+	return time.Time{}
+}
+
+// Ends returns when the event is over: the end time if set, otherwise the
+// end of the start day for all-day events or the start time itself.
+func (ev EventFile) Ends() time.Time {
+	switch {
+	case !ev.End.IsZero() && ev.AllDay:
+		return ev.End.AddDate(0, 0, 1)
+	case !ev.End.IsZero():
+		return ev.End
+	case ev.AllDay:
+		return ev.Start.AddDate(0, 0, 1)
+	}
+	return ev.Start
+}
+
+// upcomingEvents returns the localized events that are not over yet.
+func upcomingEvents(events []EventFile, locale string, l EventsPageLocale, now time.Time) []RenderEvent {
+	var out []RenderEvent
+	for _, ev := range events {
+		if !ev.Ends().After(now) {
+			continue
+		}
+		out = append(out, renderEvent(ev, locale, l))
+	}
+	return out
+}
+
+type RenderEventMonth struct {
+	Month  string
+	Events []RenderEvent
+}
+
+// groupEventsByMonth splits chronologically sorted events for the listing.
+func groupEventsByMonth(events []RenderEvent) []RenderEventMonth {
+	var months []RenderEventMonth
+	for _, ev := range events {
+		if len(months) == 0 || months[len(months)-1].Month != ev.Month {
+			months = append(months, RenderEventMonth{Month: ev.Month})
+		}
+		last := &months[len(months)-1]
+		last.Events = append(last.Events, ev)
+	}
+	return months
+}
+
+func renderEvent(ev EventFile, locale string, l EventsPageLocale) RenderEvent {
+	el := ev.It
+	if locale == "en" {
+		el = ev.En
+	}
+
+	re := RenderEvent{
+		Slug:          ev.Slug,
+		Category:      ev.Category,
+		CategoryLabel: l.Categories[ev.Category],
+		Title:         el.Title,
+		Description:   el.Description,
+		Location:      el.Location,
+		Date:          formatDateRange(locale, ev.Start, ev.End),
+		Month:         formatMonth(locale, ev.Start),
+	}
+	if re.CategoryLabel == "" {
+		re.CategoryLabel = ev.Category
+	}
+
+	if ev.AllDay {
+		re.Time = l.AllDay
+	} else {
+		re.Time = formatTime(locale, ev.Start)
+		if !ev.End.IsZero() && sameDay(ev.Start, ev.End) {
+			re.Time += " - " + formatTime(locale, ev.End)
+		}
+	}
+	return re
+}
+
+func formatDateRange(locale string, start, end time.Time) string {
+	if end.IsZero() || sameDay(start, end) {
+		return formatDate(locale, start)
+	}
+	if start.Year() == end.Year() && start.Month() == end.Month() {
+		if locale == "it" {
+			return fmt.Sprintf("%d - %s", start.Day(), formatDate(locale, end))
+		}
+		return fmt.Sprintf("%s %d - %d, %d", start.Format("January"), start.Day(), end.Day(), end.Year())
+	}
+	return formatDate(locale, start) + " - " + formatDate(locale, end)
+}
+
+func formatTime(locale string, t time.Time) string {
+	if locale == "it" {
+		return t.Format("15:04")
+	}
+	return t.Format("3:04 PM")
+}
+
+func formatMonth(locale string, t time.Time) string {
+	if locale == "it" {
+		m := monthsIt[t.Month()-1]
+		return strings.ToUpper(m[:1]) + m[1:] + fmt.Sprintf(" %d", t.Year())
+	}
+	return t.Format("January 2006")
+}
+
+func sameDay(a, b time.Time) bool {
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay()
+}
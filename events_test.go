@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadEventsTimes(t *testing.T) {
+	rome, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for name, body := range map[string]string{
+		"festa.toml":  "start = 2026-08-16\nall_day = true\nend = 2026-08-17\n",
+		"torneo.toml": "start = 2026-08-15T16:00:00\nend = 2026-08-15T19:30:00\n",
+		"utc.toml":    "start = 2026-08-14T16:00:00Z\n",
+		"offset.toml": "start = 2026-08-13T16:00:00+02:00\nend = 2026-08-13T18:00:00-01:00\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := loadEvents(dir, &SiteConfig{Location: rome})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][2]time.Time{
+		// Local dates and datetimes are wall clock in the site timezone
+		"festa":  {time.Date(2026, 8, 16, 0, 0, 0, 0, rome), time.Date(2026, 8, 17, 0, 0, 0, 0, rome)},
+		"torneo": {time.Date(2026, 8, 15, 16, 0, 0, 0, rome), time.Date(2026, 8, 15, 19, 30, 0, 0, rome)},
+		// Offset datetimes keep their instant
+		"utc":    {time.Date(2026, 8, 14, 18, 0, 0, 0, rome), {}},
+		"offset": {time.Date(2026, 8, 13, 16, 0, 0, 0, rome), time.Date(2026, 8, 13, 21, 0, 0, 0, rome)},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("loaded %d events, want %d", len(events), len(want))
+	}
+	for _, ev := range events {
+		w := want[ev.Slug]
+		if !ev.Start.Equal(w[0]) || !ev.End.Equal(w[1]) {
+			t.Errorf("%s: start, end = %v, %v, want %v, %v", ev.Slug, ev.Start, ev.End, w[0], w[1])
+		}
+		if ev.Start.Location() != rome {
+			t.Errorf("%s: start in %v, want the site timezone", ev.Slug, ev.Start.Location())
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Atom Feeds
+//
+// One feed per locale (dist/feed.xml, dist/en/feed.xml) listing the newest
+// itineraries, events and gallery additions, ordered by their published date.
+// Gallery images added on the same day are grouped into a single entry.
+
+const feedMaxEntries = 30
+
+type FeedLocale struct {
+	Title        string `toml:"title"`
+	Subtitle     string `toml:"subtitle"`
+	GalleryEntry string `toml:"gallery_entry"` // e.g. "%d new photos in the gallery"
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Lang     string      `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Author   atomPerson  `xml:"author"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Links      []atomLink     `xml:"link"`
+	Author     *atomPerson    `xml:"author,omitempty"`
+	Categories []atomCategory `xml:"category"`
+	Summary    *atomText      `xml:"summary,omitempty"`
+	Content    *atomText      `xml:"content,omitempty"`
+
+	published time.Time
+}
+
+// publishedAt returns the explicit published date, or the fallback when it is
+// not set: the first commit of the content file, which later edits do not
+// move (entries would come back as new).
+func publishedAt(d toml.LocalDate, fallback time.Time, loc *time.Location) time.Time {
+	if d == (toml.LocalDate{}) {
+		return fallback
+	}
+	return d.AsTime(loc)
+}
+
+func writeFeed(site *SiteConfig, locale string, baseUrl string, t RenderIndex, rawItineraries []ItineraryFile, rawEvents []EventFile, gallery GalleryData) {
+	root := site.BaseURL + baseUrl
+
+	var entries []atomEntry
+	for _, it := range rawItineraries {
+		l := it.It
+		if locale == "en" {
+			l = it.En
+		}
+		published := publishedAt(it.Published, it.Added, site.Location)
+		link := root + "/itineraries/" + it.Slug + ".html"
+		e := atomEntry{
+			ID:         link,
+			Title:      l.Title,
+			Links:      []atomLink{{Rel: "alternate", Type: "text/html", Href: link}},
+			Categories: []atomCategory{{Term: it.Type}},
+			Summary:    &atomText{Type: "text", Body: l.Description},
+			published:  published,
+		}
+		if it.Author != "" {
+			e.Author = &atomPerson{Name: "@" + it.Author, URI: "https://www.instagram.com/" + it.Author + "/"}
+		}
+		if it.Image != "" {
+			e.Content = &atomText{Type: "html", Body: fmt.Sprintf(`<p><img src="%s" alt="%s"></p><p>%s</p>`,
+				absoluteURL(site, it.Image), html.EscapeString(l.Title), html.EscapeString(l.Description))}
+		}
+		entries = append(entries, e)
+	}
+
+	for _, ev := range rawEvents {
+		re := renderEvent(ev, locale, t.EventsPage)
+		link := root + "/events.html#" + ev.Slug
+		summary := re.Date
+		if re.Time != "" {
+			summary += ", " + re.Time
+		}
+		if re.Location != "" {
+			summary += " - " + re.Location
+		}
+		if re.Description != "" {
+			summary += "\n\n" + re.Description
+		}
+		entries = append(entries, atomEntry{
+			ID:         link,
+			Title:      re.Title,
+			Links:      []atomLink{{Rel: "alternate", Type: "text/html", Href: link}},
+			Categories: []atomCategory{{Term: ev.Category, Label: re.CategoryLabel}},
+			Summary:    &atomText{Type: "text", Body: summary},
+			published:  publishedAt(ev.Published, ev.Added, site.Location),
+		})
+	}
+
+	entries = append(entries, galleryFeedEntries(site, root, t.Feed, gallery)...)
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].published.After(entries[j].published)
+	})
+	if len(entries) > feedMaxEntries {
+		entries = entries[:feedMaxEntries]
+	}
+
+	var updated time.Time
+	for i := range entries {
+		entries[i].Published = entries[i].published.Format(time.RFC3339)
+		entries[i].Updated = entries[i].Published
+		if entries[i].published.After(updated) {
+			updated = entries[i].published
+		}
+	}
+	if updated.IsZero() {
+		updated = time.Now().In(site.Location)
+	}
+
+	feed := atomFeed{
+		Lang:     locale,
+		ID:       root + "/",
+		Title:    t.Feed.Title,
+		Subtitle: t.Feed.Subtitle,
+		Updated:  updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: root + "/feed.xml"},
+			{Rel: "alternate", Type: "text/html", Href: root + "/"},
+		},
+		Author:  atomPerson{Name: "Bruggi", URI: site.BaseURL + "/"},
+		Entries: entries,
+	}
+
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		log.Printf("Error encoding feed: %v", err)
+		return
+	}
+	path := filepath.Join("dist", strings.TrimPrefix(baseUrl, "/"), "feed.xml")
+	if err := writeFileAtomic(path, append([]byte(xml.Header), append(out, '\n')...)); err != nil {
+		log.Printf("Error writing %s: %v", path, err)
+	}
+}
+
+// absoluteURL turns a site path into a full URL, escaping spaces and other
+// characters that are not valid in a URL path.
+func absoluteURL(site *SiteConfig, path string) string {
+	return site.BaseURL + (&url.URL{Path: path}).EscapedPath()
+}
+
+// galleryFeedEntries groups gallery images by the day they were added.
+func galleryFeedEntries(site *SiteConfig, root string, l FeedLocale, gallery GalleryData) []atomEntry {
+	var days []time.Time
+	byDay := map[time.Time][]GalleryImage{}
+	for _, img := range gallery.Images {
+		day := publishedAt(img.Added, gallery.Added, site.Location).In(site.Location)
+		day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, site.Location)
+		if _, ok := byDay[day]; !ok {
+			days = append(days, day)
+		}
+		byDay[day] = append(byDay[day], img)
+	}
+
+	var entries []atomEntry
+	for _, day := range days {
+		images := byDay[day]
+		var b strings.Builder
+		for _, img := range images {
+			fmt.Fprintf(&b, `<a href="%s"><img src="%s" alt="%s"></a> `,
+				absoluteURL(site, img.Url), absoluteURL(site, img.Thumbnail), html.EscapeString(img.Alt))
+		}
+		link := root + "/galleries.html"
+		entries = append(entries, atomEntry{
+			ID:        link + "#" + day.Format("2006-01-02"),
+			Title:     fmt.Sprintf(l.GalleryEntry, len(images)),
+			Links:     []atomLink{{Rel: "alternate", Type: "text/html", Href: link}},
+			Content:   &atomText{Type: "html", Body: strings.TrimSpace(b.String())},
+			published: day,
+		})
+	}
+	return entries
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// GeoJSON Output Structures
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoJSONGeometry   `json:"geometry"`
+	Properties geoJSONProperties `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+type geoJSONProperties struct {
+	Slug          string            `json:"slug"`
+	Title         map[string]string `json:"title"` // by locale
+	URL           map[string]string `json:"url"`   // by locale
+	Type          string            `json:"type"`
+	Difficulty    string            `json:"difficulty"`
+	DistanceKM    float64           `json:"distance_km"`
+	ElevationGain int               `json:"elevation_gain"`
+}
+
+// writeGeoJSON emits dist/static/geo/<slug>.geojson for every itinerary with
+// a track, plus all.geojson with every trail for the overview map.
+func writeGeoJSON(site *SiteConfig, itineraries []ItineraryFile) error {
+	geoDir := "dist/static/geo"
+	if err := os.MkdirAll(geoDir, 0755); err != nil {
+		return fmt.Errorf("creating geo dir: %w", err)
+	}
+
+	var errs []error
+	all := geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+	for _, it := range itineraries {
+		if it.Track == nil {
+			continue
+		}
+		feature := itineraryFeature(it, simplifyTrack(it.Track, site.Geo.SimplifyTolerance))
+		all.Features = append(all.Features, feature)
+
+		single := geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{feature}}
+		if err := writeJSON(filepath.Join(geoDir, it.Slug+".geojson"), single); err != nil {
+			errs = append(errs, fmt.Errorf("writing GeoJSON for %s: %w", it.Slug, err))
+		}
+	}
+
+	if err := writeJSON(filepath.Join(geoDir, "all.geojson"), all); err != nil {
+		errs = append(errs, fmt.Errorf("writing all.geojson: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+func itineraryFeature(it ItineraryFile, t *Track) geoJSONFeature {
+	var lines [][][]float64
+	for _, seg := range t.Segments {
+		var line [][]float64
+		for _, pt := range seg {
+			pos := []float64{roundTo(pt.Lon, 6), roundTo(pt.Lat, 6)}
+			if pt.HasEle {
+				pos = append(pos, roundTo(pt.Ele, 1))
+			}
+			line = append(line, pos)
+		}
+		lines = append(lines, line)
+	}
+
+	geometry := geoJSONGeometry{Type: "MultiLineString", Coordinates: lines}
+	if len(lines) == 1 {
+		geometry = geoJSONGeometry{Type: "LineString", Coordinates: lines[0]}
+	}
+
+	return geoJSONFeature{
+		Type:     "Feature",
+		Geometry: geometry,
+		Properties: geoJSONProperties{
+			Slug: it.Slug,
+			Title: map[string]string{
+				"it": it.It.Title,
+				"en": it.En.Title,
+			},
+			URL: map[string]string{
+				"it": "/itineraries/" + it.Slug + ".html",
+				"en": "/en/itineraries/" + it.Slug + ".html",
+			},
+			Type:          it.Type,
+			Difficulty:    it.Difficulty,
+			DistanceKM:    it.DistanceKM,
+			ElevationGain: it.ElevationGain,
+		},
+	}
+}
+
+func writeJSON(path string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+func roundTo(v float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	return math.Round(v*p) / p
+}
@@ -7,9 +7,10 @@ require (
 	github.com/flosch/pongo2/v6 v6.0.0
 	github.com/fsnotify/fsnotify v1.9.0
 	github.com/pelletier/go-toml/v2 v2.2.4
+	golang.org/x/image v0.25.0
 )
 
 require (
-	golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8 // indirect
 	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/text v0.23.0 // indirect
 )
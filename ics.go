@@ -0,0 +1,168 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// iCalendar (RFC 5545) export of the events: one .ics per event and a full
+// events.ics feed per locale that can be subscribed to.
+
+// VTIMEZONE for Europe/Rome (CET/CEST, EU rules)
+const icsRomeTimezone = `BEGIN:VTIMEZONE
+TZID:Europe/Rome
+BEGIN:DAYLIGHT
+TZOFFSETFROM:+0100
+TZOFFSETTO:+0200
+TZNAME:CEST
+DTSTART:19700329T020000
+RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU
+END:DAYLIGHT
+BEGIN:STANDARD
+TZOFFSETFROM:+0200
+TZOFFSETTO:+0100
+TZNAME:CET
+DTSTART:19701025T030000
+RRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU
+END:STANDARD
+END:VTIMEZONE`
+
+// writeEventCalendars writes <out>/events.ics and <out>/events/<slug>.ics
+// for the given locale.
+func writeEventCalendars(site *SiteConfig, locale string, baseUrl string, events []EventFile, l EventsPageLocale) {
+	outDir := filepath.Join("dist", strings.TrimPrefix(baseUrl, "/"))
+	if err := os.MkdirAll(filepath.Join(outDir, "events"), 0755); err != nil {
+		log.Printf("Error creating events dir: %v", err)
+		return
+	}
+
+	calName := "Bruggi - " + l.Title
+	var all []string
+	for _, ev := range events {
+		vevent := icsEvent(site, locale, baseUrl, ev, l)
+		all = append(all, vevent)
+
+		path := filepath.Join(outDir, "events", ev.Slug+".ics")
+		if err := writeFileAtomic(path, []byte(icsCalendar(site, locale, calName, []string{vevent}))); err != nil {
+			log.Printf("Error writing %s: %v", path, err)
+		}
+	}
+
+	path := filepath.Join(outDir, "events.ics")
+	if err := writeFileAtomic(path, []byte(icsCalendar(site, locale, calName, all))); err != nil {
+		log.Printf("Error writing %s: %v", path, err)
+	}
+}
+
+func icsCalendar(site *SiteConfig, locale string, name string, vevents []string) string {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//bruggi.it//Events//" + strings.ToUpper(locale),
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:" + icsEscape(name),
+		"X-WR-TIMEZONE:" + site.Timezone,
+	}
+	if site.Timezone == "Europe/Rome" {
+		lines = append(lines, strings.Split(icsRomeTimezone, "\n")...)
+	}
+	lines = append(lines, vevents...)
+	lines = append(lines, "END:VCALENDAR")
+
+	var b strings.Builder
+	for _, line := range lines {
+		for _, l := range strings.Split(line, "\n") {
+			b.WriteString(icsFold(l))
+			b.WriteString("\r\n")
+		}
+	}
+	return b.String()
+}
+
+func icsEvent(site *SiteConfig, locale string, baseUrl string, ev EventFile, l EventsPageLocale) string {
+	el := ev.It
+	if locale == "en" {
+		el = ev.En
+	}
+
+	// UIDs must stay stable across builds and locales so updates replace
+	// the entry in subscribed calendars instead of duplicating it
+	host := "bruggi.it"
+	if u, err := url.Parse(site.BaseURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+
+	lines := []string{
+		"BEGIN:VEVENT",
+		"UID:" + ev.Slug + "@" + host,
+		"DTSTAMP:" + ev.Updated.UTC().Format("20060102T150405Z"),
+	}
+
+	if ev.AllDay {
+		// DTEND is exclusive for dates
+		end := ev.Start.AddDate(0, 0, 1)
+		if !ev.End.IsZero() {
+			end = ev.End.AddDate(0, 0, 1)
+		}
+		lines = append(lines,
+			"DTSTART;VALUE=DATE:"+ev.Start.Format("20060102"),
+			"DTEND;VALUE=DATE:"+end.Format("20060102"))
+	} else {
+		lines = append(lines, "DTSTART"+icsDateTime(site, ev.Start))
+		if !ev.End.IsZero() {
+			lines = append(lines, "DTEND"+icsDateTime(site, ev.End))
+		}
+	}
+
+	lines = append(lines, "SUMMARY:"+icsEscape(el.Title))
+	if el.Description != "" {
+		lines = append(lines, "DESCRIPTION:"+icsEscape(el.Description))
+	}
+	if el.Location != "" {
+		lines = append(lines, "LOCATION:"+icsEscape(el.Location))
+	}
+	if label := l.Categories[ev.Category]; label != "" {
+		lines = append(lines, "CATEGORIES:"+icsEscape(label))
+	}
+	lines = append(lines,
+		"URL:"+site.BaseURL+baseUrl+"/events.html#"+ev.Slug,
+		"END:VEVENT")
+
+	return strings.Join(lines, "\n")
+}
+
+// icsDateTime formats a local time with its TZID, or in UTC when the site
+// timezone has no VTIMEZONE definition.
+func icsDateTime(site *SiteConfig, t time.Time) string {
+	if site.Timezone == "Europe/Rome" {
+		return ";TZID=Europe/Rome:" + t.Format("20060102T150405")
+	}
+	return ":" + t.UTC().Format("20060102T150405Z")
+}
+
+func icsEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// icsFold splits content lines longer than 75 octets, without breaking
+// UTF-8 sequences.
+func icsFold(line string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range line {
+		size := len(string(r))
+		if n+size > 75 {
+			b.WriteString("\r\n ")
+			n = 1
+		}
+		b.WriteRune(r)
+		n += size
+	}
+	return b.String()
+}
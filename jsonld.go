@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Schema.org JSON-LD
+//
+// Structured data is built from the same typed data the templates render,
+// so it cannot drift from the page. Templates print the "json_ld" context
+// value inside <script type="application/ld+json"> in base.html.
+
+type ldGeoCoordinates struct {
+	Type      string  `json:"@type"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type ldPropertyValue struct {
+	Type     string `json:"@type"`
+	Name     string `json:"name"`
+	Value    any    `json:"value"`
+	UnitCode string `json:"unitCode,omitempty"` // UN/CEFACT: KMT, MTR
+	UnitText string `json:"unitText,omitempty"`
+}
+
+type ldExerciseAction struct {
+	Type         string `json:"@type"`
+	ExerciseType string `json:"exerciseType"`
+	Distance     string `json:"distance,omitempty"` // e.g. "4.5 km"
+}
+
+type ldPlace struct {
+	Context     string            `json:"@context,omitempty"`
+	Type        string            `json:"@type"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	URL         string            `json:"url,omitempty"`
+	Image       string            `json:"image,omitempty"`
+	Address     string            `json:"address,omitempty"`
+	Email       string            `json:"email,omitempty"`
+	Telephone   string            `json:"telephone,omitempty"`
+	Geo         *ldGeoCoordinates `json:"geo,omitempty"`
+	HasMap      string            `json:"hasMap,omitempty"`
+	Free        bool              `json:"isAccessibleForFree,omitempty"`
+	TouristType string            `json:"touristType,omitempty"`
+	Keywords    []string          `json:"keywords,omitempty"`
+	Properties  []ldPropertyValue `json:"additionalProperty,omitempty"`
+	Action      *ldExerciseAction `json:"potentialAction,omitempty"`
+	ContainedIn *ldPlace          `json:"containedInPlace,omitempty"`
+}
+
+type ldEvent struct {
+	Type           string          `json:"@type"`
+	Name           string          `json:"name"`
+	Description    string          `json:"description,omitempty"`
+	URL            string          `json:"url"`
+	StartDate      string          `json:"startDate"`
+	EndDate        string          `json:"endDate,omitempty"`
+	Status         string          `json:"eventStatus"`
+	AttendanceMode string          `json:"eventAttendanceMode"`
+	Location       ldPlace         `json:"location"`
+	Organizer      *ldOrganization `json:"organizer,omitempty"`
+}
+
+type ldOrganization struct {
+	Type  string `json:"@type"`
+	Name  string `json:"name"`
+	URL   string `json:"url"`
+	Email string `json:"email,omitempty"`
+}
+
+type ldGraph struct {
+	Context string `json:"@context"`
+	Graph   []any  `json:"@graph"`
+}
+
+// marshalJSONLD encodes v for a script tag. encoding/json escapes <, > and &,
+// so the output cannot close the tag early.
+func marshalJSONLD(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error encoding JSON-LD: %v", err)
+		return ""
+	}
+	return string(b)
+}
+
+// villagePlace describes Bruggi itself, shared by the index and as the
+// container of itineraries and events.
+func villagePlace(site *SiteConfig, indexData *IndexFile, baseUrl string) ldPlace {
+	return ldPlace{
+		Type:      "TouristDestination",
+		Name:      "Bruggi",
+		URL:       site.BaseURL + baseUrl + "/",
+		Address:   indexData.Contacts.Address,
+		Email:     indexData.Contacts.Email,
+		Telephone: indexData.Contacts.Phone,
+		Geo:       &ldGeoCoordinates{Type: "GeoCoordinates", Latitude: site.Village.Lat, Longitude: site.Village.Lon},
+	}
+}
+
+func indexJSONLD(site *SiteConfig, indexData *IndexFile, baseUrl string, t RenderIndex) string {
+	place := villagePlace(site, indexData, baseUrl)
+	place.Context = "https://schema.org"
+	place.Description = t.Welcome.Description
+	if len(indexData.Hero.Images) > 0 {
+		place.Image = absoluteURL(site, indexData.Hero.Images[0])
+	}
+	return marshalJSONLD(place)
+}
+
+func itineraryJSONLD(site *SiteConfig, indexData *IndexFile, baseUrl string, it RenderItinerary) string {
+	village := villagePlace(site, indexData, baseUrl)
+	place := ldPlace{
+		Context:     "https://schema.org",
+		Type:        "TouristAttraction",
+		Name:        it.Title,
+		Description: it.Description,
+		URL:         site.BaseURL + baseUrl + "/itineraries/" + it.Slug + ".html",
+		Free:        true,
+		TouristType: it.Type,
+		Keywords:    it.Tags,
+		ContainedIn: &village,
+	}
+	if it.Image != "" {
+		place.Image = absoluteURL(site, it.Image)
+	}
+	if it.HasTrack {
+		place.Geo = &ldGeoCoordinates{Type: "GeoCoordinates", Latitude: it.Trailhead.Lat, Longitude: it.Trailhead.Lon}
+		place.HasMap = absoluteURL(site, it.GpxFile)
+	}
+
+	exercise := "Hiking"
+	if it.Type == "biking" {
+		exercise = "Mountain biking"
+	}
+	place.Action = &ldExerciseAction{Type: "ExerciseAction", ExerciseType: exercise}
+	if it.DistanceKM > 0 {
+		place.Action.Distance = strconv.FormatFloat(it.DistanceKM, 'f', -1, 64) + " km"
+		place.Properties = append(place.Properties, ldPropertyValue{
+			Type: "PropertyValue", Name: "distance", Value: it.DistanceKM, UnitCode: "KMT", UnitText: "km"})
+	}
+	if it.ElevationGain > 0 {
+		place.Properties = append(place.Properties, ldPropertyValue{
+			Type: "PropertyValue", Name: "elevationGain", Value: it.ElevationGain, UnitCode: "MTR", UnitText: "m"})
+	}
+	if it.Difficulty != "" {
+		place.Properties = append(place.Properties, ldPropertyValue{
+			Type: "PropertyValue", Name: "difficulty", Value: it.Difficulty})
+	}
+	if it.Duration != "" {
+		place.Properties = append(place.Properties, ldPropertyValue{
+			Type: "PropertyValue", Name: "duration", Value: it.Duration})
+	}
+	return marshalJSONLD(place)
+}
+
+// eventsJSONLD describes the upcoming events. Events carry their own dates,
+// so they are built from EventFile rather than the localized RenderEvent.
+func eventsJSONLD(site *SiteConfig, indexData *IndexFile, locale string, baseUrl string, events []EventFile, now time.Time) string {
+	village := villagePlace(site, indexData, baseUrl)
+	graph := ldGraph{Context: "https://schema.org"}
+	for _, ev := range events {
+		if !ev.Ends().After(now) {
+			continue
+		}
+		el := ev.It
+		if locale == "en" {
+			el = ev.En
+		}
+
+		location := village
+		if el.Location != "" {
+			location = ldPlace{Type: "Place", Name: el.Location, Address: indexData.Contacts.Address, ContainedIn: &village}
+		}
+		e := ldEvent{
+			Type:           "Event",
+			Name:           el.Title,
+			Description:    el.Description,
+			URL:            site.BaseURL + baseUrl + "/events.html#" + ev.Slug,
+			Status:         "https://schema.org/EventScheduled",
+			AttendanceMode: "https://schema.org/OfflineEventAttendanceMode",
+			Location:       location,
+			Organizer:      &ldOrganization{Type: "Organization", Name: "Bruggi", URL: site.BaseURL + baseUrl + "/", Email: indexData.Contacts.Email},
+		}
+		if ev.AllDay {
+			e.StartDate = ev.Start.Format(time.DateOnly)
+			if !ev.End.IsZero() {
+				e.EndDate = ev.End.Format(time.DateOnly)
+			}
+		} else {
+			e.StartDate = ev.Start.Format(time.RFC3339)
+			if !ev.End.IsZero() {
+				e.EndDate = ev.End.Format(time.RFC3339)
+			}
+		}
+		graph.Graph = append(graph.Graph, e)
+	}
+	if len(graph.Graph) == 0 {
+		return ""
+	}
+	return marshalJSONLD(graph)
+}
@@ -1,18 +1,21 @@
 package main
 
 import (
-	"encoding/xml"
 	"flag"
 	"fmt"
+	"image"
 	"io"
 	"io/fs"
 	"log"
 	"math"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
+	_ "time/tzdata" // Site timezone must resolve on minimal hosts too
 
 	"github.com/disintegration/imaging"
 	"github.com/flosch/pongo2/v6"
@@ -22,20 +25,61 @@ import (
 
 // Data Structures
 
-type IndexFile struct {
-	Hero         SharedHeroSection        `toml:"hero"`
-	Welcome      SharedWelcomeSection     `toml:"welcome"`
-	Itineraries  SharedItinerariesSection `toml:"itineraries"`
-	Contacts     SharedContacts           `toml:"contacts"`
-	AugustEvents SharedAugustEvents       `toml:"august_events"`
-	It           IndexLocale              `toml:"it"`
-	En           IndexLocale              `toml:"en"`
+type SiteConfig struct {
+	BaseURL  string         `toml:"base_url"` // Absolute URL of the published site, without trailing slash
+	Timezone string         `toml:"timezone"` // IANA name, dates in content files are in this zone
+	Location *time.Location `toml:"-"`
+	Village  LatLon         `toml:"village"` // Village center
+	Gpx      GpxConfig      `toml:"gpx"`
+	Geo      GeoConfig      `toml:"geo"`
+	Dem      DemConfig      `toml:"dem"`
+	Robots   RobotsConfig   `toml:"robots"`
+	Maps     MapsConfig     `toml:"maps"`
+	Tiles    TilesConfig    `toml:"tiles"`
+	Webcam   WebcamConfig   `toml:"webcam"`
+	Webcams  []WebcamCamera `toml:"webcams"`
+	Weather  WeatherConfig  `toml:"weather"`
+}
+
+type MapsConfig struct {
+	TileDir     string `toml:"tile_dir"`    // Local XYZ tile cache for thumbnails, <z>/<x>/<y>.png
+	Attribution string `toml:"attribution"` // Credit drawn on thumbnails that use tiles
+}
+
+type DemConfig struct {
+	Dir  string `toml:"dir"`  // Directory with SRTM .hgt or GeoTIFF tiles, empty disables
+	Mode string `toml:"mode"` // "fill" (only missing elevations) or "replace"
+}
+
+type LatLon struct {
+	Lat float64 `toml:"lat"`
+	Lon float64 `toml:"lon"`
+}
+
+type BoundingBox struct {
+	MinLat float64
+	MinLon float64
+	MaxLat float64
+	MaxLon float64
 }
 
-type EventsFile struct {
-	Enabled bool               `toml:"enabled"`
-	It      AugustEventsLocale `toml:"it"`
-	En      AugustEventsLocale `toml:"en"`
+type GpxConfig struct {
+	SimplifyTolerance float64 `toml:"simplify_tolerance"` // Douglas-Peucker tolerance in meters, 0 disables
+	LoopThreshold     float64 `toml:"loop_threshold"`     // Max start/end distance in meters for a loop
+}
+
+type GeoConfig struct {
+	SimplifyTolerance float64 `toml:"simplify_tolerance"` // Coarser than GPX: only used for map overlays
+}
+
+type IndexFile struct {
+	Hero        SharedHeroSection        `toml:"hero"`
+	Welcome     SharedWelcomeSection     `toml:"welcome"`
+	Itineraries SharedItinerariesSection `toml:"itineraries"`
+	Contacts    SharedContacts           `toml:"contacts"`
+	It          IndexLocale              `toml:"it"`
+	En          IndexLocale              `toml:"en"`
+	Updated     time.Time                `toml:"-"` // Last change of index.toml
 }
 
 type SharedHeroSection struct {
@@ -46,10 +90,6 @@ type SharedWelcomeSection struct {
 	Image string `toml:"image"`
 }
 
-type SharedAugustEvents struct {
-	Enabled bool `toml:"enabled"`
-}
-
 type SharedItinerariesSection struct {
 	HeroImage string `toml:"hero_image"`
 }
@@ -68,37 +108,36 @@ type IndexLocale struct {
 	ItineraryPage ItineraryPageLocale `toml:"itinerary_page"`
 	WebcamPage    WebcamPageLocale    `toml:"webcam_page"`
 	ContactInfo   ContactInfoLocale   `toml:"contact_info"`
-	AugustEvents  AugustEventsLocale  `toml:"august_events"`
+	EventsPage    EventsPageLocale    `toml:"events_page"`
+	Feed          FeedLocale          `toml:"feed"`
 	Footer        FooterLocale        `toml:"footer"`
 }
 
-type AugustEventsLocale struct {
-	Title string      `toml:"title"`
-	Items []EventItem `toml:"items"`
-}
-
-type EventItem struct {
-	Name string `toml:"name"`
-	Date string `toml:"date"`
-	Time string `toml:"time"`
-}
-
 type ItineraryPageLocale struct {
-	TrailDetails     string `toml:"trail_details"`
-	Author           string `toml:"author"`
-	Type             string `toml:"type"`
-	TypeHiking       string `toml:"type_hiking"`
-	TypeBiking       string `toml:"type_biking"`
-	Duration         string `toml:"duration"`
-	Distance         string `toml:"distance"`
-	ElevationGain    string `toml:"elevation_gain"`
-	DownloadGPX      string `toml:"download_gpx"`
-	GPXNotAvailable  string `toml:"gpx_not_available"`
-	Description      string `toml:"description"`
-	Difficulty       string `toml:"difficulty"`
-	DifficultyEasy   string `toml:"difficulty_easy"`
-	DifficultyMedium string `toml:"difficulty_medium"`
-	DifficultyHard   string `toml:"difficulty_hard"`
+	TrailDetails      string `toml:"trail_details"`
+	Author            string `toml:"author"`
+	Type              string `toml:"type"`
+	TypeHiking        string `toml:"type_hiking"`
+	TypeBiking        string `toml:"type_biking"`
+	Duration          string `toml:"duration"`
+	Distance          string `toml:"distance"`
+	ElevationGain     string `toml:"elevation_gain"`
+	DownloadGPX       string `toml:"download_gpx"`
+	GPXNotAvailable   string `toml:"gpx_not_available"`
+	Description       string `toml:"description"`
+	Difficulty        string `toml:"difficulty"`
+	DifficultyEasy    string `toml:"difficulty_easy"`
+	DifficultyMedium  string `toml:"difficulty_medium"`
+	DifficultyHard    string `toml:"difficulty_hard"`
+	NavigateTrailhead string `toml:"navigate_trailhead"`
+	Loop              string `toml:"loop"`
+	OneWay            string `toml:"one_way"`
+	FromVillage       string `toml:"from_village"`
+	NoticesTitle      string `toml:"notices_title"`
+	NoticeUntil       string `toml:"notice_until"`
+	SaveOffline       string `toml:"save_offline"`
+	SavedOffline      string `toml:"saved_offline"`
+	SaveOfflineFailed string `toml:"save_offline_failed"`
 }
 
 type ContactInfoLocale struct {
@@ -117,13 +156,23 @@ type ContactInfoLocale struct {
 type WebcamPageLocale struct {
 	Live            string `toml:"live"`
 	HD              string `toml:"hd"`
-	PanoramaTitle   string `toml:"panorama_title"`
-	Location        string `toml:"location"`
 	Share           string `toml:"share"`
 	Snapshot        string `toml:"snapshot"`
 	Timelapse       string `toml:"timelapse"`
+	TimelapseToday  string `toml:"timelapse_today"`
+	TimelapseYday   string `toml:"timelapse_yesterday"`
+	TimelapseWeek   string `toml:"timelapse_week"`
 	StatusOnline    string `toml:"status_online"`
+	StatusOffline   string `toml:"status_offline"`
+	RecentFrames    string `toml:"recent_frames"`
+	Archive         string `toml:"archive"`
+	ArchiveFrames   string `toml:"archive_frames"` // After a number: "12 scatti"
+	BackToLive      string `toml:"back_to_live"`
 	NextUpdate      string `toml:"next_update"`
+	FrameUpdated    string `toml:"frame_updated"`
+	MinutesAgo      string `toml:"minutes_ago"` // {n} is replaced by the page script
+	InMinutes       string `toml:"in_minutes"`
+	JustNow         string `toml:"just_now"`
 	ReportIssue     string `toml:"report_issue"`
 	ConditionsTitle string `toml:"conditions_title"`
 	UpdatedAgo      string `toml:"updated_ago"`
@@ -133,17 +182,18 @@ type WebcamPageLocale struct {
 	Direction       string `toml:"direction"`
 	Humidity        string `toml:"humidity"`
 	Precip          string `toml:"precip"`
-	Visibility      string `toml:"visibility"`
-	VisRange        string `toml:"vis_range"`
-	VisGood         string `toml:"vis_good"`
-	VisPoor         string `toml:"vis_poor"`
-	VisModerate     string `toml:"vis_moderate"`
+	Pressure        string `toml:"pressure"`
+	PressureRising  string `toml:"pressure_rising"`
+	PressureFalling string `toml:"pressure_falling"`
+	PressureSteady  string `toml:"pressure_steady"`
+	StationOffline  string `toml:"station_offline"`
 }
 
 type NavLocale struct {
 	Home        string `toml:"home"`
 	Itineraries string `toml:"itineraries"`
 	Webcam      string `toml:"webcam"`
+	Events      string `toml:"events"`
 	Gallery     string `toml:"gallery"`
 	Contact     string `toml:"contact"`
 }
@@ -184,31 +234,45 @@ type FooterLocale struct {
 }
 
 type GalleryData struct {
-	Images []GalleryImage `toml:"images"`
+	Images  []GalleryImage `toml:"images"`
+	Updated time.Time      `toml:"-"` // Last change of galleries.toml
+	Added   time.Time      `toml:"-"` // First commit of galleries.toml
 }
 
 type GalleryImage struct {
-	Url       string `toml:"url"`
-	Alt       string `toml:"alt"`
-	Author    string `toml:"author"` // Instagram handle
-	Thumbnail string // Populated during load
+	Url       string         `toml:"url"`
+	Alt       string         `toml:"alt"`
+	Author    string         `toml:"author"` // Instagram handle
+	Added     toml.LocalDate `toml:"added"`  // Day the photo was added, for the feed
+	Thumbnail string         // Populated during load
 }
 
 type ItineraryFile struct {
-	Slug             string          `toml:"slug"`
-	Type             string          `toml:"type"`
-	Image            string          `toml:"image"`
-	GpxFile          string          `toml:"gpx_file"`
-	YoutubeVideoID   string          `toml:"youtube_video_id"`
-	Gallery          []string        `toml:"gallery"`
-	ProcessedGallery []GalleryImage  `toml:"-"`
-	Difficulty       string          `toml:"difficulty"`
-	DistanceKM       float64         `toml:"distance_km"`
-	Duration         string          `toml:"duration"`
-	ElevationGain    int             `toml:"elevation_gain"`
-	Author           string          `toml:"author"` // Instagram handle
-	It               ItineraryLocale `toml:"it"`
-	En               ItineraryLocale `toml:"en"`
+	Slug             string            `toml:"slug"`
+	Type             string            `toml:"type"`
+	Image            string            `toml:"image"`
+	GpxFile          string            `toml:"gpx_file"`
+	YoutubeVideoID   string            `toml:"youtube_video_id"`
+	Gallery          []string          `toml:"gallery"`
+	ProcessedGallery []GalleryImage    `toml:"-"`
+	Track            *Track            `toml:"-"`
+	PublishedGpx     string            `toml:"-"` // GPX download, converted from GpxFile if needed
+	Trailhead        LatLon            `toml:"-"` // First track point
+	Bounds           BoundingBox       `toml:"-"`
+	IsLoop           bool              `toml:"-"`
+	VillageDistance  float64           `toml:"-"` // Trailhead distance from the village center, km
+	OfflineTiles     []string          `toml:"-"` // Packaged map tiles around the track
+	Difficulty       string            `toml:"difficulty"`
+	DistanceKM       float64           `toml:"distance_km"`
+	Duration         string            `toml:"duration"`
+	ElevationGain    int               `toml:"elevation_gain"`
+	Author           string            `toml:"author"` // Instagram handle
+	Notices          []ItineraryNotice `toml:"notices"`
+	Published        toml.LocalDate    `toml:"published"`
+	Updated          time.Time         `toml:"-"` // Last change of the content file
+	Added            time.Time         `toml:"-"` // First commit of the content file
+	It               ItineraryLocale   `toml:"it"`
+	En               ItineraryLocale   `toml:"en"`
 }
 
 type ItineraryLocale struct {
@@ -220,21 +284,31 @@ type ItineraryLocale struct {
 
 // Renderable Item for Templates
 type RenderItinerary struct {
-	Slug           string
-	Type           string
-	Image          string
-	GpxFile        string
-	YoutubeVideoID string
-	Gallery        []GalleryImage
-	Difficulty     string
-	DistanceKM     float64
-	Duration       string
-	ElevationGain  int
-	Author         string
-	Title          string
-	Description    string
-	LongDesc       string
-	Tags           []string
+	Slug            string
+	Type            string
+	Image           string
+	GpxFile         string
+	YoutubeVideoID  string
+	Gallery         []GalleryImage
+	Difficulty      string
+	DistanceKM      float64
+	Duration        string
+	ElevationGain   int
+	Author          string
+	Title           string
+	Description     string
+	LongDesc        string
+	Tags            []string
+	HasTrack        bool
+	Trailhead       LatLon
+	TrailheadGeoURI string
+	Bounds          BoundingBox
+	IsLoop          bool
+	VillageDistance float64
+	Notices         []RenderNotice // Active at build time
+	OgImage         string         // 1200x630 share image for this locale
+	MapThumbnail    string         // Track drawn on a static map, empty without a track
+	OfflineTiles    bool           // Map tiles for the area are packaged in dist/static/tiles
 }
 
 // Helper struct to pass to templates, flattening the structure
@@ -248,26 +322,37 @@ type RenderIndex struct {
 	WebcamPage    RenderWebcamPage
 	Contacts      SharedContacts
 	ContactInfo   ContactInfoLocale
-	AugustEvents  RenderAugustEvents
+	EventsPage    EventsPageLocale
+	Feed          FeedLocale
 	Footer        FooterLocale
 }
 
-type RenderAugustEvents struct {
-	Enabled bool
-	Title   string
-	Items   []EventItem
-}
-
 type RenderWebcamPage struct {
 	Live            string
 	HD              string
-	PanoramaTitle   string
+	PanoramaTitle   string // Camera name
 	Location        string
+	Camera          string  // URL of the camera's static dir
+	Lat             float64 // Camera coordinates
+	Lon             float64
+	Cameras         []WebcamCameraLink
+	ArchiveUrl      string // Newest month of the archive, empty without frames
+	Archive         string
+	ArchiveFrames   string
+	BackToLive      string
 	Share           string
 	Snapshot        string
 	Timelapse       string
+	TimelapseToday  string
+	TimelapseYday   string
+	TimelapseWeek   string
 	StatusOnline    string
+	StatusOffline   string
 	NextUpdate      string
+	FrameUpdated    string
+	MinutesAgo      string
+	InMinutes       string
+	JustNow         string
 	ReportIssue     string
 	ConditionsTitle string
 	UpdatedAgo      string
@@ -277,18 +362,24 @@ type RenderWebcamPage struct {
 	Direction       string
 	Humidity        string
 	Precip          string
-	Visibility      string
-	VisRange        string
-	VisGood         string
-	VisPoor         string
-	VisModerate     string
-	Images          []string
+	Pressure        string
+	PressureRising  string
+	PressureFalling string
+	PressureSteady  string
+	StationOffline  string
+	Timelapses      []TimelapseClip
+	RecentFrames    string
+	Recent          []WebcamFrameLink // Newest first
+	Online          bool              // A good frame arrived within offline_after_minutes at render time
+	LastFrame       string            // RFC 3339 time of the last good frame, empty if there is none
+	OfflineAfter    int               // Minutes, for the page to re-check the status when loaded later
 }
 
 type RenderNav struct {
 	Home        string
 	Itineraries string
 	Webcam      string
+	Events      string
 	Gallery     string
 	Contact     string
 }
@@ -313,10 +404,17 @@ type RenderWelcome struct {
 func main() {
 	serveMode := flag.Bool("serve", false, "Watch for changes and serve the site")
 	webcamUpdate := flag.String("update-webcam", "", "Path to new webcam image to add")
+	webcamID := flag.String("camera", "", "Webcam id for -update-webcam, default the first [[webcams]] entry")
+	webcamDaemon := flag.Bool("webcam-daemon", false, "Ingest webcam images from the incoming dir and HTTP uploads")
+	weatherUpdate := flag.String("update-weather", "", "Path to weather station readings to add (JSON, WeeWX or Ecowitt CSV), - for stdin")
 	flag.Parse()
 
 	if *webcamUpdate != "" {
-		handleWebcamUpdate(*webcamUpdate)
+		handleWebcamUpdate(*webcamUpdate, *webcamID)
+	} else if *weatherUpdate != "" {
+		handleWeatherUpdate(*weatherUpdate)
+	} else if *webcamDaemon {
+		runWebcamDaemon()
 	} else if *serveMode {
 		watchAndServe()
 	} else {
@@ -324,105 +422,235 @@ func main() {
 	}
 }
 
-func handleWebcamUpdate(srcPath string) {
-	fmt.Printf("Updating webcam with image: %s\n", srcPath)
+func handleWebcamUpdate(srcPath string, camID string) {
+	site, err := loadSite("content/site.toml")
+	if err != nil {
+		log.Fatalf("Error loading site config: %v", err)
+	}
+	cam, err := findWebcam(site, camID)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	fmt.Printf("Updating webcam %s with image: %s\n", cam.ID, srcPath)
+
+	accepted, err := ingestWebcamFrames(site, cam, []webcamFrame{{Path: srcPath, Time: time.Now()}})
+	if err != nil {
+		log.Fatalf("Error updating webcam: %v", err)
+	}
+	if accepted == 0 {
+		log.Fatalf("Webcam image rejected, kept in %s", site.Webcam.For(cam).QuarantineDir)
+	}
+	fmt.Println("Webcam update complete.")
+}
+
+// webcamFrame is a new webcam image and its capture time.
+type webcamFrame struct {
+	Path string
+	Time time.Time
+}
+
+// ingestWebcamFrames validates frames of cam (oldest first), archives the
+// good ones, makes the newest good one current.jpg, prunes the archive and
+// re-renders the camera's pages. It returns how many frames were accepted.
+// Shared by -update-webcam and -webcam-daemon.
+func ingestWebcamFrames(site *SiteConfig, cam WebcamCamera, frames []webcamFrame) (int, error) {
+	unlock, err := lockSite()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	cfg := site.Webcam.For(cam)
+	// Names, quarantine names and stamps are in the site timezone
+	for i := range frames {
+		frames[i].Time = frames[i].Time.In(site.Location)
+	}
 
 	// 1. Prepare Paths
-	webcamDir := "static/webcam"
+	webcamDir := cam.Dir()
 	if err := os.MkdirAll(webcamDir, 0755); err != nil {
-		log.Fatalf("Error creating webcam dir: %v", err)
+		return 0, fmt.Errorf("creating webcam dir: %w", err)
+	}
+	before, err := webcamSnapshots(webcamDir, site.Location)
+	if err != nil {
+		return 0, fmt.Errorf("listing webcam snapshots: %w", err)
 	}
 
-	distWebcamDir := "dist/static/webcam"
+	distWebcamDir := cam.DistDir()
 	// Ensure dist exists (if not, we might be running this without a previous build,
 	// but we try to support it)
 	if err := os.MkdirAll(distWebcamDir, 0755); err != nil {
-		log.Fatalf("Error creating dist webcam dir: %v", err)
+		return 0, fmt.Errorf("creating dist webcam dir: %w", err)
+	}
+
+	// 2. Validate: the frame must decode, be bright and detailed enough and
+	// differ from the previous good one (current.jpg). Snapshots are named to
+	// the second: a frame whose name is already taken would overwrite it.
+	prev, _ := probeWebcamFrame(filepath.Join(webcamDir, "current.jpg"))
+	taken := make(map[string]bool)
+	var good []webcamFrame
+	for _, frame := range frames {
+		probe, err := probeWebcamFrame(frame.Path)
+		reason := "corrupt"
+		if err == nil {
+			reason = checkWebcamFrame(cfg, probe, prev)
+		}
+		name := webcamSnapshotName(frame.Time)
+		if reason == "" && !taken[name] {
+			if _, err := os.Stat(filepath.Join(webcamDir, name)); err == nil {
+				taken[name] = true
+			}
+		}
+		if reason == "" && taken[name] {
+			reason = "duplicate"
+		}
+		if reason != "" {
+			log.Printf("Warning: webcam frame %s rejected: %s", frame.Path, reason)
+			if err := quarantineWebcamFrame(cfg, frame, reason); err != nil {
+				return 0, fmt.Errorf("quarantining %s: %w", frame.Path, err)
+			}
+			continue
+		}
+		good = append(good, frame)
+		taken[name] = true
+		prev = probe
+	}
+
+	// 3. Archive each good frame under its timestamp, in static/webcam (Source
+	// of Truth) and dist/static/webcam (Served Content), with its web and
+	// thumbnail versions
+	for _, frame := range good {
+		timestampName := webcamSnapshotName(frame.Time)
+		for _, dir := range []string{webcamDir, distWebcamDir} {
+			if err := copyFile(frame.Path, filepath.Join(dir, timestampName)); err != nil {
+				return 0, fmt.Errorf("adding timestamped image in %s: %w", dir, err)
+			}
+		}
+		if err := writeWebcamDerivatives(cfg, frame.Path, timestampName, frame.Time, webcamDir, distWebcamDir); err != nil {
+			return 0, fmt.Errorf("making derivatives of %s: %w", timestampName, err)
+		}
 	}
 
-	// 2. Generate Filenames
-	currentName := "current.jpg"
-
-	now := time.Now()
-	timestampName := fmt.Sprintf("%s.jpg", now.Format("2006-01-02_15-04-05"))
+	// 4. Replace current.jpg (and its versions) with the newest good frame
+	if len(good) > 0 {
+		latest := good[len(good)-1]
+		latestName := webcamSnapshotName(latest.Time)
+		for _, dir := range []string{webcamDir, distWebcamDir} {
+			if err := copyFile(latest.Path, filepath.Join(dir, "current.jpg")); err != nil {
+				return 0, fmt.Errorf("updating current.jpg in %s: %w", dir, err)
+			}
+			for _, sub := range []string{webcamWebDir, webcamThumbDir} {
+				if err := copyFile(filepath.Join(webcamDir, sub, latestName), filepath.Join(dir, sub, "current.jpg")); err != nil {
+					return 0, fmt.Errorf("updating %s/current.jpg in %s: %w", sub, dir, err)
+				}
+			}
+		}
+		if cam.ID == site.Webcams[0].ID {
+			if err := writeLegacyWebcamCurrent(site); err != nil {
+				return 0, fmt.Errorf("updating %s: %w", legacyWebcamCurrent, err)
+			}
+		}
+	}
 
-	// 3. Copy files to static/webcam (Source of Truth)
-	if err := copyFile(srcPath, filepath.Join(webcamDir, currentName)); err != nil {
-		log.Fatalf("Error updating current.jpg in static: %v", err)
+	// 5. Thin out the archive, every copy so dist matches static
+	var archiveDirs []string
+	for _, dir := range []string{webcamDir, distWebcamDir} {
+		archiveDirs = append(archiveDirs, dir, filepath.Join(dir, webcamWebDir), filepath.Join(dir, webcamThumbDir))
 	}
-	if err := copyFile(srcPath, filepath.Join(webcamDir, timestampName)); err != nil {
-		log.Fatalf("Error adding timestamped image in static: %v", err)
+	removed, err := pruneWebcamArchive(cfg, time.Now().In(site.Location), archiveDirs...)
+	if err != nil {
+		return 0, fmt.Errorf("pruning webcam archive: %w", err)
+	}
+	if removed > 0 {
+		fmt.Printf("Removed %d old webcam images\n", removed)
 	}
 
-	// 4. Copy files to dist/static/webcam (Served Content)
-	if err := copyFile(srcPath, filepath.Join(distWebcamDir, currentName)); err != nil {
-		log.Fatalf("Error updating current.jpg in dist: %v", err)
+	// 6. Re-encode the time-lapses whose frames changed, and the frame list
+	if err := writeTimelapses(site, cam, time.Now(), false); err != nil {
+		log.Printf("Error writing time-lapses: %v", err)
 	}
-	if err := copyFile(srcPath, filepath.Join(distWebcamDir, timestampName)); err != nil {
-		log.Fatalf("Error adding timestamped image in dist: %v", err)
+	if err := writeWebcamIndex(site, cam); err != nil {
+		return 0, fmt.Errorf("writing webcam index: %w", err)
 	}
 
-	// 5. Update Pages
-	indexData, err := loadIndex("content/index.toml")
+	// 7. Update Pages, also when every frame was rejected: the camera status
+	// changes. Only the archive pages of days that gained or lost frames
+	after, err := webcamSnapshots(webcamDir, site.Location)
 	if err != nil {
-		log.Fatalf("Error loading index: %v", err)
+		return 0, fmt.Errorf("listing webcam snapshots: %w", err)
 	}
-	eventsData, err := loadEvents("content/august_events.toml")
+	indexData, err := loadIndex("content/index.toml")
 	if err != nil {
-		log.Fatalf("Error loading events: %v", err)
+		return 0, fmt.Errorf("loading index: %w", err)
 	}
+	updateWebcamPages(site, cam, indexData, webcamArchiveChanges(before, after))
+	return len(good), nil
+}
 
-	updateWebcamPages(indexData, eventsData)
-	fmt.Println("Webcam update complete.")
+func updateWebcamPages(site *SiteConfig, cam WebcamCamera, indexData *IndexFile, change *webcamArchiveChange) {
+	// Re-render ONLY the camera's page for IT and EN
+	now := time.Now()
+	renderWebcamPage(site, cam, "it", "", createRenderIndex("it", indexData), now, change)
+	renderWebcamPage(site, cam, "en", "/en", createRenderIndex("en", indexData), now, change)
 }
 
-func updateWebcamPages(indexData *IndexFile, eventsData *EventsFile) {
-	// Re-render ONLY webcam.html for IT and EN
+// renderWebcamPage renders the page of cam and its archive pages, shared by
+// the build and the webcam updates. change limits the archive pages rendered,
+// nil renders all of them.
+func renderWebcamPage(site *SiteConfig, cam WebcamCamera, locale string, baseUrl string, renderIndex RenderIndex, now time.Time, change *webcamArchiveChange) {
+	fillWebcamPage(site, cam, locale, &renderIndex.WebcamPage, now)
 
-	webcamImages, err := loadWebcamImages("static/webcam")
-	if err != nil {
-		log.Printf("Error loading webcam images: %v", err)
+	ctx := pongo2.Context{
+		"locale":        locale,
+		"base_url":      baseUrl,
+		"alternate_url": computeAlternateUrl(locale, cam.Page),
+		"page_title":    "Bruggi Webcams",
+		"t":             renderIndex,
+	}
+	if len(site.Webcams) > 1 {
+		ctx["page_title"] = renderIndex.WebcamPage.PanoramaTitle
 	}
 
-	render := func(locale string, baseUrl string, outPath string) {
-		renderIndex := createRenderIndex(locale, indexData, eventsData)
-		renderIndex.WebcamPage.Images = webcamImages
-
-		ctx := pongo2.Context{
-			"locale":        locale,
-			"base_url":      baseUrl,
-			"alternate_url": computeAlternateUrl(locale, "/webcam.html"),
-			"page_title":    "Bruggi Webcams",
-			"t":             renderIndex,
-		}
-
-		// Ensure output dir exists
-		if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
-			log.Panic(err)
-		}
-
-		tpl := pongo2.Must(pongo2.FromFile("templates/webcam.html"))
-		if err := renderToFile(tpl, ctx, outPath); err != nil {
-			log.Panic(err)
-		}
+	outPath := filepath.Join("dist", strings.TrimPrefix(baseUrl, "/"), cam.Page)
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		log.Panic(err)
+	}
+	tpl := pongo2.Must(pongo2.FromFile("templates/webcam.html"))
+	if err := renderToFile(tpl, ctx, outPath); err != nil {
+		log.Panic(err)
 	}
 
-	render("it", "", "dist/webcam.html")
-	render("en", "/en", "dist/en/webcam.html")
+	renderWebcamArchive(site, cam, locale, baseUrl, renderIndex, change)
 }
 
+// siteLockPath is the lock file of lockSite.
+const siteLockPath = ".bruggi.lock"
+
 func buildSite() {
+	unlock, err := lockSite()
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return
+	}
+	defer unlock()
+
 	fmt.Println("Building site...")
 	start := time.Now()
 
 	// 1. Load Data
+	site, err := loadSite("content/site.toml")
+	if err != nil {
+		log.Printf("Error loading site config: %v", err)
+		return
+	}
+
 	indexData, err := loadIndex("content/index.toml")
 	if err != nil {
 		log.Printf("Error loading index: %v", err)
 		return
 	}
 
-	eventsData, err := loadEvents("content/august_events.toml")
+	events, err := loadEvents("content/events", site)
 	if err != nil {
 		log.Printf("Error loading events: %v", err)
 		return
@@ -434,17 +662,24 @@ func buildSite() {
 		return
 	}
 
-	itineraries, err := loadItineraries("content/itineraries")
+	// Optional DEM tiles, for track elevations and the map thumbnails' hillshade
+	var dem *DEM
+	if site.Dem.Dir != "" {
+		dem, err = loadDEM(site.Dem.Dir)
+		if err != nil {
+			log.Printf("Warning: DEM disabled: %v", err)
+		}
+	}
+
+	itineraries, err := loadItineraries("content/itineraries", site, dem)
 	if err != nil {
 		log.Printf("Error loading itineraries: %v", err)
 		return
 	}
 
-	// 2. Prepare Output Directory
-	if err := os.RemoveAll("dist"); err != nil {
-		log.Printf("Error clearing dist: %v", err)
-		return
-	}
+	// 2. Prepare Output Directory. dist is not cleared: files are replaced
+	// atomically and the ones this build did not write are removed at the end,
+	// so the site stays whole while building or if the build is interrupted.
 	if err := os.MkdirAll("dist", 0755); err != nil {
 		log.Printf("Error creating dist: %v", err)
 		return
@@ -458,14 +693,78 @@ func buildSite() {
 		return
 	}
 
-	// Copy Static Files
-	copyDir("static", "dist/static")
+	// Steps below go on after an error, but a failed step may not have
+	// rewritten all its outputs: the stale files are then kept (see
+	// removeStaleOutputs), so a failure never takes good files off the site.
+	var failed []string
+	check := func(step string, err error) {
+		if err != nil {
+			log.Printf("Error %s: %v", step, err)
+			failed = append(failed, step)
+		}
+	}
+	for _, it := range itineraries {
+		if it.GpxFile != "" && it.Track == nil {
+			failed = append(failed, "loading track of "+it.Slug) // Logged by loadItineraries
+		}
+	}
+
+	// Webcam web and thumbnail versions, into static/webcam like thumbnails
+	for _, cam := range site.Webcams {
+		backfillWebcamDerivatives(site, cam)
+	}
+
+	// Copy Static Files. Track sources are not served, only the cleaned GPX
+	// written by publishTracks
+	check("copying static files", copyDir("static", "dist/static", isTrackSource))
+	check("writing "+legacyWebcamCurrent, writeLegacyWebcamCurrent(site))
+
+	// Webcam time-lapses and frame lists from the archives just copied
+	for _, cam := range site.Webcams {
+		check("writing time-lapses of "+cam.ID, writeTimelapses(site, cam, time.Now(), true))
+		check("writing webcam index of "+cam.ID, writeWebcamIndex(site, cam))
+	}
+
+	// Current conditions from the weather station history just copied
+	check("writing weather summary", writeWeather(site))
+
+	// Publish cleaned GPX downloads (simplified, no timestamps/extensions)
+	check("publishing GPX", publishTracks(site, itineraries))
+
+	// Map data: per-itinerary GeoJSON and the combined overview
+	check("writing GeoJSON", writeGeoJSON(site, itineraries))
+
+	// Offline map tiles around each track, from a local MBTiles file
+	check("writing offline tiles", writeOfflineTiles(site, itineraries))
+
+	// Route thumbnails, also used in the share images
+	mapThumbs, err := writeMapThumbnails(site, itineraries, dem)
+	check("writing map thumbnails", err)
+
+	// Social share images
+	check("writing Open Graph images", writeOgImages(indexData, itineraries, mapThumbs))
 
 	// 3. Render Pages for IT (Default)
-	renderLocale("it", "", indexData, eventsData, *galleryData, itineraries)
+	pages := renderLocale(site, "it", "", indexData, events, *galleryData, itineraries)
 
 	// 4. Render Pages for EN
-	renderLocale("en", "/en", indexData, eventsData, *galleryData, itineraries)
+	pages = append(pages, renderLocale(site, "en", "/en", indexData, events, *galleryData, itineraries)...)
+
+	// Search engines: sitemap with hreflang alternates, robots.txt pointing to it
+	check("writing sitemap", writeSitemap(site, pages))
+	check("writing robots.txt", writeRobots(site))
+
+	// Drop outputs of previous builds that no longer exist (removed pages, tiles...),
+	// before the service worker lists what is in dist. Anything not written
+	// since start is removed, so only when every step above succeeded.
+	if len(failed) > 0 {
+		log.Printf("Keeping stale files in dist, failed: %s", strings.Join(failed, "; "))
+	} else if err := removeStaleOutputs("dist", start); err != nil {
+		log.Printf("Error removing stale files from dist: %v", err)
+	}
+
+	// Installable app, service worker precache computed from the files in dist
+	writePWA(indexData, itineraries, pages)
 
 	// 5. Cleanup Unused Images
 	// usedImages := collectUsedImages(indexData, galleryData, itineraries)
@@ -510,7 +809,7 @@ func watchAndServe() {
 	}()
 
 	// Add directories to watch
-	dirsToWatch := []string{"content", "content/itineraries", "templates", "static"}
+	dirsToWatch := []string{"content", "content/itineraries", "content/events", "templates", "static"}
 	for _, dir := range dirsToWatch {
 		err = watcher.Add(dir)
 		if err != nil {
@@ -532,15 +831,12 @@ func watchAndServe() {
 	<-done
 }
 
-func createRenderIndex(locale string, indexData *IndexFile, eventsData *EventsFile) RenderIndex {
+func createRenderIndex(locale string, indexData *IndexFile) RenderIndex {
 	var l IndexLocale
-	var el AugustEventsLocale
 	if locale == "it" {
 		l = indexData.It
-		el = eventsData.It
 	} else {
 		l = indexData.En
-		el = eventsData.En
 	}
 
 	return RenderIndex{
@@ -548,6 +844,7 @@ func createRenderIndex(locale string, indexData *IndexFile, eventsData *EventsFi
 			Home:        l.Nav.Home,
 			Itineraries: l.Nav.Itineraries,
 			Webcam:      l.Nav.Webcam,
+			Events:      l.Nav.Events,
 			Gallery:     l.Nav.Gallery,
 			Contact:     l.Nav.Contact,
 		},
@@ -571,21 +868,28 @@ func createRenderIndex(locale string, indexData *IndexFile, eventsData *EventsFi
 		ItineraryPage: l.ItineraryPage,
 		Contacts:      indexData.Contacts,
 		ContactInfo:   l.ContactInfo,
-		AugustEvents: RenderAugustEvents{
-			Enabled: eventsData.Enabled,
-			Title:   el.Title,
-			Items:   el.Items,
-		},
+		EventsPage:    l.EventsPage,
+		Feed:          l.Feed,
 		WebcamPage: RenderWebcamPage{
 			Live:            l.WebcamPage.Live,
 			HD:              l.WebcamPage.HD,
-			PanoramaTitle:   l.WebcamPage.PanoramaTitle,
-			Location:        l.WebcamPage.Location,
 			Share:           l.WebcamPage.Share,
 			Snapshot:        l.WebcamPage.Snapshot,
 			Timelapse:       l.WebcamPage.Timelapse,
+			TimelapseToday:  l.WebcamPage.TimelapseToday,
+			TimelapseYday:   l.WebcamPage.TimelapseYday,
+			TimelapseWeek:   l.WebcamPage.TimelapseWeek,
 			StatusOnline:    l.WebcamPage.StatusOnline,
+			StatusOffline:   l.WebcamPage.StatusOffline,
+			RecentFrames:    l.WebcamPage.RecentFrames,
+			Archive:         l.WebcamPage.Archive,
+			ArchiveFrames:   l.WebcamPage.ArchiveFrames,
+			BackToLive:      l.WebcamPage.BackToLive,
 			NextUpdate:      l.WebcamPage.NextUpdate,
+			FrameUpdated:    l.WebcamPage.FrameUpdated,
+			MinutesAgo:      l.WebcamPage.MinutesAgo,
+			InMinutes:       l.WebcamPage.InMinutes,
+			JustNow:         l.WebcamPage.JustNow,
 			ReportIssue:     l.WebcamPage.ReportIssue,
 			ConditionsTitle: l.WebcamPage.ConditionsTitle,
 			UpdatedAgo:      l.WebcamPage.UpdatedAgo,
@@ -595,11 +899,11 @@ func createRenderIndex(locale string, indexData *IndexFile, eventsData *EventsFi
 			Direction:       l.WebcamPage.Direction,
 			Humidity:        l.WebcamPage.Humidity,
 			Precip:          l.WebcamPage.Precip,
-			Visibility:      l.WebcamPage.Visibility,
-			VisRange:        l.WebcamPage.VisRange,
-			VisGood:         l.WebcamPage.VisGood,
-			VisPoor:         l.WebcamPage.VisPoor,
-			VisModerate:     l.WebcamPage.VisModerate,
+			Pressure:        l.WebcamPage.Pressure,
+			PressureRising:  l.WebcamPage.PressureRising,
+			PressureFalling: l.WebcamPage.PressureFalling,
+			PressureSteady:  l.WebcamPage.PressureSteady,
+			StationOffline:  l.WebcamPage.StationOffline,
 		},
 		Footer: FooterLocale{
 			Motto:         l.Footer.Motto,
@@ -610,13 +914,39 @@ func createRenderIndex(locale string, indexData *IndexFile, eventsData *EventsFi
 	}
 }
 
-func renderLocale(locale string, baseUrl string, indexData *IndexFile, eventsData *EventsFile, galleryT GalleryData, rawItineraries []ItineraryFile) {
+// renderLocale writes all pages of a locale and returns them for the sitemap.
+func renderLocale(site *SiteConfig, locale string, baseUrl string, indexData *IndexFile, rawEvents []EventFile, galleryT GalleryData, rawItineraries []ItineraryFile) []SitemapPage {
 	// Merge shared and localized
-	renderIndex := createRenderIndex(locale, indexData, eventsData)
+	renderIndex := createRenderIndex(locale, indexData)
+
+	// Sitemap lastmod: the latest change of the content files behind each page
+	var pages []SitemapPage
+	itineraryMod := map[string]time.Time{}
+	itinerariesMod := indexData.Updated
+	for _, raw := range rawItineraries {
+		itineraryMod[raw.Slug] = latestTime(indexData.Updated, raw.Updated)
+		itinerariesMod = latestTime(itinerariesMod, raw.Updated)
+	}
+	eventsMod := indexData.Updated
+	for _, ev := range rawEvents {
+		eventsMod = latestTime(eventsMod, ev.Updated)
+	}
+	galleryMod := latestTime(indexData.Updated, galleryT.Updated)
+
+	// Notices are filtered at build time, scheduled builds drop expired ones
+	now := time.Now()
+	var siteNotices []RenderNotice
 
 	// Prepare Itineraries for this locale
 	var localItineraries []RenderItinerary
 	for _, raw := range rawItineraries {
+		notices := activeNotices(raw, locale, now, site.Location)
+		for _, n := range notices {
+			if n.SiteWide {
+				siteNotices = append(siteNotices, n)
+			}
+		}
+
 		// Filter: Only include itineraries with a GPX file
 		if raw.GpxFile == "" {
 			continue
@@ -626,28 +956,59 @@ func renderLocale(locale string, baseUrl string, indexData *IndexFile, eventsDat
 		if locale == "en" {
 			l = raw.En
 		}
-		localItineraries = append(localItineraries, RenderItinerary{
-			Slug:           raw.Slug,
-			Type:           raw.Type,
-			Image:          raw.Image,
-			GpxFile:        raw.GpxFile,
-			YoutubeVideoID: raw.YoutubeVideoID,
-			Gallery:        raw.ProcessedGallery, // Use processed gallery
-			Difficulty:     raw.Difficulty,
-			DistanceKM:     raw.DistanceKM,
-			Duration:       raw.Duration,
-			ElevationGain:  raw.ElevationGain,
-			Author:         raw.Author,
-			Title:          l.Title,
-			Description:    l.Description,
-			LongDesc:       l.LongDesc,
-			Tags:           l.Tags,
-		})
-	}
-
-	webcamImages, err := loadWebcamImages("static/webcam")
-	if err != nil {
-		log.Printf("Error loading webcam images: %v", err)
+
+		// RFC 5870 geo URI, opens the navigation app on mobile
+		var geoURI string
+		if raw.Track != nil {
+			geoURI = fmt.Sprintf("geo:%.6f,%.6f", raw.Trailhead.Lat, raw.Trailhead.Lon)
+		}
+
+		ri := RenderItinerary{
+			Slug:            raw.Slug,
+			Type:            raw.Type,
+			Image:           raw.Image,
+			GpxFile:         raw.PublishedGpx,
+			YoutubeVideoID:  raw.YoutubeVideoID,
+			Gallery:         raw.ProcessedGallery, // Use processed gallery
+			Difficulty:      raw.Difficulty,
+			DistanceKM:      raw.DistanceKM,
+			Duration:        raw.Duration,
+			ElevationGain:   raw.ElevationGain,
+			Author:          raw.Author,
+			Title:           l.Title,
+			Description:     l.Description,
+			LongDesc:        l.LongDesc,
+			Tags:            l.Tags,
+			HasTrack:        raw.Track != nil,
+			Trailhead:       raw.Trailhead,
+			TrailheadGeoURI: geoURI,
+			Bounds:          raw.Bounds,
+			IsLoop:          raw.IsLoop,
+			VillageDistance: raw.VillageDistance,
+			Notices:         notices,
+			OgImage:         ogImagePath(locale, raw.Slug),
+			OfflineTiles:    len(raw.OfflineTiles) > 0,
+		}
+		if raw.Track != nil {
+			ri.MapThumbnail = mapThumbPath(raw.Slug)
+		}
+		localItineraries = append(localItineraries, ri)
+	}
+
+	// Closest trailheads first. Tracks that failed to load sort last.
+	sort.SliceStable(localItineraries, func(i, j int) bool {
+		a, b := localItineraries[i], localItineraries[j]
+		if a.HasTrack != b.HasTrack {
+			return a.HasTrack
+		}
+		return a.VillageDistance < b.VillageDistance
+	})
+
+	// Upcoming events (past ones are hidden); the index shows the next 6
+	events := upcomingEvents(rawEvents, locale, renderIndex.EventsPage, now)
+	indexEvents := events
+	if len(indexEvents) > 6 {
+		indexEvents = indexEvents[:6]
 	}
 
 	// Limit gallery images for the index page to 8
@@ -665,21 +1026,22 @@ func renderLocale(locale string, baseUrl string, indexData *IndexFile, eventsDat
 		"t":              renderIndex, // We pass our flattened struct as 't'
 		"gallery_images": indexGalleryImages,
 		"itineraries":    localItineraries,
+		"site_notices":   siteNotices,
+		"events":         indexEvents,
+		"json_ld":        indexJSONLD(site, indexData, baseUrl, renderIndex),
 	}
 
-	// Update WebcamPage with loaded images
-	renderIndex.WebcamPage.Images = webcamImages
-
 	tpl := pongo2.Must(pongo2.FromFile("templates/index.html"))
 	outPath := "dist/index.html"
 	if locale == "en" {
 		outPath = "dist/en/index.html"
 	}
 
-	err = renderToFile(tpl, ctx, outPath)
+	err := renderToFile(tpl, ctx, outPath)
 	if err != nil {
 		log.Panic(err)
 	}
+	pages = append(pages, SitemapPage{Path: "/", LastMod: latestTime(itinerariesMod, eventsMod, galleryMod)})
 
 	// Render Galleries
 	galleryCtx := pongo2.Context{
@@ -698,22 +1060,12 @@ func renderLocale(locale string, baseUrl string, indexData *IndexFile, eventsDat
 	if err := renderToFile(galTpl, galleryCtx, galOutPath); err != nil {
 		log.Panic(err)
 	}
+	pages = append(pages, SitemapPage{Path: "/galleries.html", LastMod: galleryMod})
 
-	// Render Webcam
-	webcamCtx := pongo2.Context{
-		"locale":        locale,
-		"base_url":      baseUrl,
-		"alternate_url": computeAlternateUrl(locale, "/webcam.html"),
-		"page_title":    "Bruggi Webcams",
-		"t":             renderIndex,
-	}
-	webcamTpl := pongo2.Must(pongo2.FromFile("templates/webcam.html"))
-	webcamOutPath := "dist/webcam.html"
-	if locale == "en" {
-		webcamOutPath = "dist/en/webcam.html"
-	}
-	if err := renderToFile(webcamTpl, webcamCtx, webcamOutPath); err != nil {
-		log.Panic(err)
+	// Render Webcams, one page per camera
+	for _, cam := range site.Webcams {
+		renderWebcamPage(site, cam, locale, baseUrl, renderIndex, now, nil)
+		pages = append(pages, SitemapPage{Path: cam.Page, LastMod: indexData.Updated})
 	}
 
 	// Render Contacts
@@ -732,6 +1084,32 @@ func renderLocale(locale string, baseUrl string, indexData *IndexFile, eventsDat
 	if err := renderToFile(contactsTpl, contactsCtx, contactsOutPath); err != nil {
 		log.Panic(err)
 	}
+	pages = append(pages, SitemapPage{Path: "/contacts.html", LastMod: indexData.Updated})
+
+	// Render Events
+	eventsCtx := pongo2.Context{
+		"locale":        locale,
+		"base_url":      baseUrl,
+		"alternate_url": computeAlternateUrl(locale, "/events.html"),
+		"page_title":    renderIndex.EventsPage.Title,
+		"t":             renderIndex,
+		"event_months":  groupEventsByMonth(events),
+		"json_ld":       eventsJSONLD(site, indexData, locale, baseUrl, rawEvents, now),
+	}
+	eventsTpl := pongo2.Must(pongo2.FromFile("templates/events.html"))
+	eventsOutPath := "dist/events.html"
+	if locale == "en" {
+		eventsOutPath = "dist/en/events.html"
+	}
+	if err := renderToFile(eventsTpl, eventsCtx, eventsOutPath); err != nil {
+		log.Panic(err)
+	}
+	pages = append(pages, SitemapPage{Path: "/events.html", LastMod: eventsMod})
+
+	// Calendar files include past events so subscribers keep their history
+	writeEventCalendars(site, locale, baseUrl, rawEvents, renderIndex.EventsPage)
+
+	writeFeed(site, locale, baseUrl, renderIndex, rawItineraries, rawEvents, galleryT)
 
 	// Render Itineraries List (All + Filtered)
 	filters := []string{"all", "hiking", "biking"}
@@ -792,6 +1170,7 @@ func renderLocale(locale string, baseUrl string, indexData *IndexFile, eventsDat
 		if err := renderToFile(listTpl, listCtx, listOutPath); err != nil {
 			log.Panic(err)
 		}
+		pages = append(pages, SitemapPage{Path: relativePath, LastMod: itinerariesMod})
 	}
 
 	// Render Itinerary Details
@@ -809,56 +1188,122 @@ func renderLocale(locale string, baseUrl string, indexData *IndexFile, eventsDat
 	for _, it := range localItineraries {
 		relativePath := "/itineraries/" + it.Slug + ".html"
 		detailCtx := pongo2.Context{
-			"locale":        locale,
-			"base_url":      baseUrl,
-			"alternate_url": computeAlternateUrl(locale, relativePath),
-			"page_title":    it.Title,
-			"itinerary":     it,
-			"t":             renderIndex, // Pass main translations if needed for header/footer
+			"locale":         locale,
+			"base_url":       baseUrl,
+			"alternate_url":  computeAlternateUrl(locale, relativePath),
+			"page_title":     it.Title,
+			"itinerary":      it,
+			"t":              renderIndex, // Pass main translations if needed for header/footer
+			"json_ld":        itineraryJSONLD(site, indexData, baseUrl, it),
+			"og_image":       absoluteURL(site, it.OgImage),
+			"og_url":         site.BaseURL + baseUrl + relativePath,
+			"og_description": it.Description,
+			"tiles":          site.Tiles,
 		}
 		detailOutPath := filepath.Join(itineraryOutDir, it.Slug+".html")
 		if err := renderToFile(detailTpl, detailCtx, detailOutPath); err != nil {
 			log.Panic(err)
 		}
+		pages = append(pages, SitemapPage{Path: relativePath, LastMod: itineraryMod[it.Slug]})
 	}
+
+	return pages
 }
 
 func renderToFile(tpl *pongo2.Template, ctx pongo2.Context, path string) error {
-	f, err := os.Create(path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	return tpl.ExecuteWriter(ctx, f)
+	return writeAtomic(path, func(w io.Writer) error {
+		return tpl.ExecuteWriter(ctx, w)
+	})
 }
 
-func loadIndex(path string) (*IndexFile, error) {
+func loadSite(path string) (*SiteConfig, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	var data IndexFile
+	var data SiteConfig
 	if err := toml.Unmarshal(b, &data); err != nil {
 		return nil, err
 	}
-	for _, img := range data.Hero.Images {
-		validatePath(img)
-	}
-	validatePath(data.Welcome.Image)
-	validatePath(data.Itineraries.HeroImage)
+	data.BaseURL = strings.TrimSuffix(data.BaseURL, "/")
 
+	if data.Timezone == "" {
+		data.Timezone = "Europe/Rome"
+	}
+	if data.Location, err = time.LoadLocation(data.Timezone); err != nil {
+		return nil, err
+	}
+	switch data.Dem.Mode {
+	case "":
+		data.Dem.Mode = demModeFill
+	case demModeFill, demModeReplace:
+	default:
+		return nil, fmt.Errorf("dem.mode %q: want %q or %q", data.Dem.Mode, demModeFill, demModeReplace)
+	}
+	if data.Tiles.MinZoom == 0 && data.Tiles.MaxZoom == 0 {
+		data.Tiles.MinZoom, data.Tiles.MaxZoom = 12, 16
+	}
+	if data.Webcam.KeepAllHours == 0 && data.Webcam.HourlyDays == 0 && data.Webcam.DailyDays == 0 {
+		data.Webcam.KeepAllHours, data.Webcam.HourlyDays, data.Webcam.DailyDays = 48, 30, 365
+	}
+	if data.Webcam.TimelapseWidth <= 0 {
+		data.Webcam.TimelapseWidth = 480
+	}
+	if data.Webcam.TimelapseFrames <= 0 {
+		data.Webcam.TimelapseFrames = 72
+	}
+	if data.Webcam.MinBrightness == 0 && data.Webcam.MinContrast == 0 && data.Webcam.FrozenThreshold == 0 {
+		data.Webcam.MinBrightness, data.Webcam.MinContrast, data.Webcam.FrozenThreshold = 20, 8, 1
+	}
+	if data.Webcam.QuarantineDir == "" {
+		data.Webcam.QuarantineDir = "quarantine/webcam"
+	}
+	if data.Webcam.OfflineAfterMinutes <= 0 {
+		data.Webcam.OfflineAfterMinutes = 30
+	}
+	if data.Webcam.CaptureIntervalMinutes <= 0 {
+		data.Webcam.CaptureIntervalMinutes = 5
+	}
+	if data.Webcam.WebWidth <= 0 {
+		data.Webcam.WebWidth = 1600
+	}
+	if data.Webcam.ThumbWidth <= 0 {
+		data.Webcam.ThumbWidth = 320
+	}
+	if data.Webcam.Watermark == "" {
+		data.Webcam.Watermark = "bruggi.it"
+	}
+	if data.Webcam.DebounceSeconds <= 0 {
+		data.Webcam.DebounceSeconds = 2
+	}
+	if data.Weather.HistoryDays <= 0 {
+		data.Weather.HistoryDays = 7
+	}
+	if data.Weather.StaleAfterMinutes <= 0 {
+		data.Weather.StaleAfterMinutes = 30
+	}
+	if err := setupWebcams(&data); err != nil {
+		return nil, err
+	}
 	return &data, nil
 }
 
-func loadEvents(path string) (*EventsFile, error) {
+func loadIndex(path string) (*IndexFile, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	var data EventsFile
+	var data IndexFile
 	if err := toml.Unmarshal(b, &data); err != nil {
 		return nil, err
 	}
+	for _, img := range data.Hero.Images {
+		validatePath(img)
+	}
+	validatePath(data.Welcome.Image)
+	validatePath(data.Itineraries.HeroImage)
+	data.Updated = contentModTime(path)
+
 	return &data, nil
 }
 
@@ -871,6 +1316,8 @@ func loadGallery(path string) (*GalleryData, error) {
 	if err := toml.Unmarshal(b, &data); err != nil {
 		return nil, err
 	}
+	data.Updated = contentModTime(path)
+	data.Added = contentAddedTime(path)
 	for i := range data.Images {
 		validatePath(data.Images[i].Url)
 		url, thumb, err := processImage(data.Images[i].Url)
@@ -885,8 +1332,46 @@ func loadGallery(path string) (*GalleryData, error) {
 	return &data, nil
 }
 
-func loadItineraries(dir string) ([]ItineraryFile, error) {
+// contentModTime returns when a content file last changed: the last commit
+// touching it, or the file modification time when it has uncommitted changes
+// or is not in git.
+func contentModTime(path string) time.Time {
+	status, err := exec.Command("git", "status", "--porcelain", "--", path).Output()
+	if err == nil && len(strings.TrimSpace(string(status))) == 0 {
+		out, err := exec.Command("git", "log", "-1", "--format=%cI", "--", path).Output()
+		if err == nil {
+			if t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(out))); err == nil {
+				return t
+			}
+		}
+	}
+	if info, err := os.Stat(path); err == nil {
+		return info.ModTime()
+	}
+	return time.Now()
+}
+
+// contentAddedTime returns when a content file was first committed, a date
+// that stays put when the file is edited later, or its modification time
+// when it is not committed yet.
+func contentAddedTime(path string) time.Time {
+	out, err := exec.Command("git", "log", "--follow", "--diff-filter=A", "--format=%cI", "--", path).Output()
+	if lines := strings.Fields(string(out)); err == nil && len(lines) > 0 {
+		if t, err := time.Parse(time.RFC3339, lines[len(lines)-1]); err == nil {
+			return t
+		}
+	}
+	if info, err := os.Stat(path); err == nil {
+		return info.ModTime()
+	}
+	return time.Now()
+}
+
+// loadItineraries reads the itineraries and their tracks, with elevations
+// corrected from dem unless it is nil.
+func loadItineraries(dir string, site *SiteConfig, dem *DEM) ([]ItineraryFile, error) {
 	var its []ItineraryFile
+
 	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -903,25 +1388,37 @@ func loadItineraries(dir string) ([]ItineraryFile, error) {
 
 			validatePath(it.Image)
 			validatePath(it.GpxFile)
+			validateNotices(&it)
+			it.Updated = contentModTime(path)
+			it.Added = contentAddedTime(path)
 
 			if it.GpxFile != "" {
-				// Calculate Elevation Gain
-				// Handle both relative path from content/itineraries or absolute-ish path
-				// The GpxFile string usually comes as "gpx/foo.gpx" or "/static/gpx/foo.gpx"
-				// We need the filesystem path: "static/gpx/foo.gpx"
-
-				cleanPath := it.GpxFile
-				if strings.HasPrefix(cleanPath, "/static/") {
-					cleanPath = strings.TrimPrefix(cleanPath, "/static/")
-				}
-				fsPath := filepath.Join("static", cleanPath)
+				// Load the track and calculate elevation gain and distance.
+				// GpxFile may point at any supported format (GPX, FIT, KML/KMZ, GeoJSON);
+				// non-GPX tracks are published as a converted GPX download.
+				fsPath := staticFsPath(it.GpxFile)
 
-				gain, dist, err := processGpx(fsPath)
+				track, err := loadTrack(fsPath)
 				if err != nil {
-					log.Printf("Warning: failed to process GPX %s: %v", fsPath, err)
+					// No download: the raw file is never published
+					log.Printf("Warning: failed to process track %s: %v", fsPath, err)
 				} else {
-					it.ElevationGain = gain
-					it.DistanceKM = dist
+					if dem != nil {
+						n := correctElevations(track, dem, site.Dem.Mode)
+						log.Printf("DEM: corrected %d elevations for %s", n, it.Slug)
+					}
+
+					it.Track = track
+					it.ElevationGain, it.DistanceKM = trackStats(track)
+					it.PublishedGpx = publishedGpxUrl(it.GpxFile)
+
+					// Trailhead, extent and shape
+					points := track.Points()
+					start, end := points[0], points[len(points)-1]
+					it.Trailhead = LatLon{Lat: start.Lat, Lon: start.Lon}
+					it.Bounds = trackBounds(track)
+					it.IsLoop = haversine(start.Lat, start.Lon, end.Lat, end.Lon) <= site.Gpx.LoopThreshold
+					it.VillageDistance = math.Round(haversine(site.Village.Lat, site.Village.Lon, start.Lat, start.Lon)/100) / 10
 				}
 			}
 
@@ -945,7 +1442,9 @@ func loadItineraries(dir string) ([]ItineraryFile, error) {
 	return its, err
 }
 
-func copyDir(src string, dst string) error {
+// copyDir copies src into dst, except the files for which skip (given the
+// path relative to src) returns true.
+func copyDir(src string, dst string, skip func(rel string) bool) error {
 	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -959,12 +1458,11 @@ func copyDir(src string, dst string) error {
 		if d.IsDir() {
 			return os.MkdirAll(destPath, 0755)
 		}
-
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return err
+		if skip != nil && skip(rel) {
+			return nil
 		}
-		return os.WriteFile(destPath, data, 0644)
+
+		return copyFile(path, destPath)
 	})
 }
 
@@ -1112,33 +1610,13 @@ func processImage(rawPath string) (originalWeb string, thumbWeb string, err erro
 	// Resize to width 600, preserving aspect ratio
 	thumbImg := imaging.Resize(srcImg, 600, 0, imaging.Lanczos)
 
-	if err := imaging.Save(thumbImg, thumbPath); err != nil {
+	if err := saveImage(thumbImg, thumbPath); err != nil {
 		return "", "", fmt.Errorf("failed to save thumbnail: %w", err)
 	}
 
 	return "/static/" + cleanPath, "/static/thumbs/" + cleanPath, nil
 }
 
-func loadWebcamImages(dir string) ([]string, error) {
-	var images []string
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return images, nil
-		}
-		return nil, err
-	}
-
-	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(strings.ToLower(entry.Name()), ".jpg") {
-			if entry.Name() != "current.jpg" {
-				images = append(images, "/static/webcam/"+entry.Name())
-			}
-		}
-	}
-	return images, nil
-}
-
 func copyFile(src, dst string) error {
 	in, err := os.Open(src)
 	if err != nil {
@@ -1146,98 +1624,101 @@ func copyFile(src, dst string) error {
 	}
 	defer in.Close()
 
-	out, err := os.Create(dst)
+	return writeAtomic(dst, func(w io.Writer) error {
+		_, err := io.Copy(w, in)
+		return err
+	})
+}
+
+// writeAtomic writes path through a temp file in the same directory, renamed
+// over it once complete: visitors, the nginx cache and an interrupted build
+// only ever see the old file or the whole new one.
+func writeAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
 	if err != nil {
 		return err
 	}
-	defer out.Close()
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
 
-	if _, err = io.Copy(out, in); err != nil {
+	err = write(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644) // CreateTemp uses 0600
+	}
+	if err != nil {
 		return err
 	}
-	return out.Sync()
+	return os.Rename(tmp.Name(), path)
 }
 
-// GPX Parsing Structures
-
-type Gpx struct {
-	Trk []Trk `xml:"trk"`
-}
-
-type Trk struct {
-	TrkSeg []TrkSeg `xml:"trkseg"`
-}
-
-type TrkSeg struct {
-	TrkPt []TrkPt `xml:"trkpt"`
-}
-
-type TrkPt struct {
-	Lat float64 `xml:"lat,attr"`
-	Lon float64 `xml:"lon,attr"`
-	Ele float64 `xml:"ele"`
+func writeFileAtomic(path string, data []byte) error {
+	return writeAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
 }
 
-func processGpx(path string) (elevationGain int, distanceKm float64, err error) {
-	f, err := os.Open(path)
+// saveImage is imaging.Save through writeAtomic.
+func saveImage(img image.Image, path string, opts ...imaging.EncodeOption) error {
+	format, err := imaging.FormatFromFilename(path)
 	if err != nil {
-		return 0, 0, err
-	}
-	defer f.Close()
-
-	var gpx Gpx
-	if err := xml.NewDecoder(f).Decode(&gpx); err != nil {
-		return 0, 0, err
-	}
-
-	var gain float64
-	var dist float64
-	var prevEle float64
-	var prevLat, prevLon float64
-	first := true
-
-	for _, trk := range gpx.Trk {
-		for _, seg := range trk.TrkSeg {
-			for _, pt := range seg.TrkPt {
-				if first {
-					prevEle = pt.Ele
-					prevLat = pt.Lat
-					prevLon = pt.Lon
-					first = false
-					continue
-				}
-
-				// Elevation Gain
-				diff := pt.Ele - prevEle
-				if diff > 0 {
-					gain += diff
-				}
-				prevEle = pt.Ele
+		return err
+	}
+	return writeAtomic(path, func(w io.Writer) error {
+		return imaging.Encode(w, img, format, opts...)
+	})
+}
 
-				// Distance
-				dist += haversine(prevLat, prevLon, pt.Lat, pt.Lon)
-				prevLat = pt.Lat
-				prevLon = pt.Lon
-			}
+// removeStaleOutputs deletes what a build did not write: every output is
+// renamed into place during the build, so older files (removed pages, leftover
+// temp files of an interrupted build) predate start. Empty dirs go too.
+//
+// This relies on every build step rewriting all of its outputs, even
+// unchanged ones: a step that skips up-to-date files in dist would have them
+// deleted here. Caches belong in static/ (like thumbnails), which is copied
+// in full on every build. A step that fails may have skipped some outputs,
+// so buildSite only calls this when every step succeeded.
+func removeStaleOutputs(dir string, start time.Time) error {
+	// Some filesystems store coarse mtimes
+	cutoff := start.Truncate(2 * time.Second)
+	var dirs []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			dirs = append(dirs, path)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().Before(cutoff) {
+			return os.Remove(path)
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-
-	return int(math.Round(gain)), math.Round((dist/1000)*100) / 100, nil
+	// Deepest first, os.Remove fails on dirs that still have files
+	for i := len(dirs) - 1; i > 0; i-- {
+		os.Remove(dirs[i])
+	}
+	return nil
 }
 
-func haversine(lat1, lon1, lat2, lon2 float64) float64 {
-	const R = 6371000 // Earth radius in meters
-	phi1 := lat1 * math.Pi / 180
-	phi2 := lat2 * math.Pi / 180
-	deltaPhi := (lat2 - lat1) * math.Pi / 180
-	deltaLambda := (lon2 - lon1) * math.Pi / 180
-
-	a := math.Sin(deltaPhi/2)*math.Sin(deltaPhi/2) +
-		math.Cos(phi1)*math.Cos(phi2)*
-			math.Sin(deltaLambda/2)*math.Sin(deltaLambda/2)
-	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
-
-	return R * c
+// staticFsPath maps a web path to its filesystem location under static/.
+// Paths come as "gpx/foo.gpx" or "/static/gpx/foo.gpx".
+func staticFsPath(webPath string) string {
+	cleanPath := strings.TrimPrefix(webPath, "/static/")
+	return filepath.Join("static", cleanPath)
 }
 
 func validatePath(path string) {
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	_ "golang.org/x/image/webp"
+)
+
+// Static Map Thumbnails
+//
+// Each track is drawn into dist/static/maps/<slug>.png (Web Mercator, at the
+// largest integer zoom that fits) so cards and share images can show the
+// route shape without Leaflet. The background is, in order of preference:
+// tiles from a local cache, hillshading from the DEM, or a plain color.
+
+const (
+	mapThumbWidth  = 600
+	mapThumbHeight = 400
+	mapThumbPad    = 30 // Pixels kept free around the track
+	mapTileSize    = 256
+	mapMaxZoom     = 17
+)
+
+var (
+	mapPlain    = color.NRGBA{0xf6, 0xf8, 0xf6, 0xff} // background-light
+	mapLine     = color.NRGBA{0x11, 0xd4, 0x11, 0xff} // primary
+	mapCasing   = color.NRGBA{0xff, 0xff, 0xff, 0xff}
+	mapTrailEnd = color.NRGBA{0x10, 0x22, 0x10, 0xff}
+)
+
+// tileSource provides 256px XYZ raster tiles. Tile returns nil when the tile
+// is not available.
+type tileSource interface {
+	Tile(z, x, y int) image.Image
+}
+
+// dirTiles reads a local tile cache laid out as <dir>/<z>/<x>/<y>.png (or
+// .jpg, .webp).
+type dirTiles string
+
+func (d dirTiles) Tile(z, x, y int) image.Image {
+	for _, ext := range []string{".png", ".jpg", ".jpeg", ".webp"} {
+		path := filepath.Join(string(d), fmt.Sprint(z), fmt.Sprint(x), fmt.Sprint(y)+ext)
+		if img, err := imaging.Open(path); err == nil {
+			return img
+		}
+	}
+	return nil
+}
+
+// mapThumbPath returns the web path of an itinerary map thumbnail.
+func mapThumbPath(slug string) string {
+	return "/static/maps/" + slug + ".png"
+}
+
+// mercatorPixel projects a position to global pixel coordinates at zoom z.
+func mercatorPixel(lat, lon float64, z int) (float64, float64) {
+	scale := mapTileSize * math.Exp2(float64(z))
+	x := (lon + 180) / 360 * scale
+	sin := math.Sin(lat * math.Pi / 180)
+	y := (0.5 - math.Log((1+sin)/(1-sin))/(4*math.Pi)) * scale
+	return x, y
+}
+
+// mercatorLatLon is the inverse of mercatorPixel.
+func mercatorLatLon(x, y float64, z int) (float64, float64) {
+	scale := mapTileSize * math.Exp2(float64(z))
+	lon := x/scale*360 - 180
+	n := math.Pi - 2*math.Pi*y/scale
+	lat := 180 / math.Pi * math.Atan(math.Sinh(n))
+	return lat, lon
+}
+
+// mapView is the window of the global pixel space a thumbnail shows.
+type mapView struct {
+	Zoom   int
+	Left   float64 // Global pixel x of the left edge
+	Top    float64
+	Width  int
+	Height int
+}
+
+// fitMapView picks the largest zoom at which the bounds fit the image,
+// centered.
+func fitMapView(b BoundingBox, width, height int) mapView {
+	z := mapMaxZoom
+	for ; z > 0; z-- {
+		x0, y0 := mercatorPixel(b.MaxLat, b.MinLon, z)
+		x1, y1 := mercatorPixel(b.MinLat, b.MaxLon, z)
+		if x1-x0 <= float64(width-2*mapThumbPad) && y1-y0 <= float64(height-2*mapThumbPad) {
+			break
+		}
+	}
+	x0, y0 := mercatorPixel(b.MaxLat, b.MinLon, z)
+	x1, y1 := mercatorPixel(b.MinLat, b.MaxLon, z)
+	return mapView{
+		Zoom:   z,
+		Left:   math.Round((x0+x1)/2 - float64(width)/2),
+		Top:    math.Round((y0+y1)/2 - float64(height)/2),
+		Width:  width,
+		Height: height,
+	}
+}
+
+// writeMapThumbnails renders a thumbnail for every itinerary with a track and
+// returns them by slug, for reuse in the share images. dem, when not nil,
+// shades the background where there are no tiles.
+func writeMapThumbnails(site *SiteConfig, its []ItineraryFile, dem *DEM) (map[string]image.Image, error) {
+	var tiles tileSource
+	if site.Maps.TileDir != "" {
+		tiles = dirTiles(site.Maps.TileDir)
+	} else if site.Tiles.Format != "" {
+		tiles = dirTiles("dist/static/tiles") // Packaged offline tiles
+	}
+
+	if err := os.MkdirAll("dist/static/maps", 0755); err != nil {
+		return nil, fmt.Errorf("creating maps dir: %w", err)
+	}
+
+	var errs []error
+	thumbs := make(map[string]image.Image)
+	for _, it := range its {
+		if it.Track == nil {
+			continue
+		}
+		img := renderMapThumbnail(site, it.Track, it.Bounds, tiles, dem)
+		path := filepath.Join("dist", filepath.FromSlash(mapThumbPath(it.Slug)))
+		if err := saveImage(img, path); err != nil {
+			errs = append(errs, fmt.Errorf("writing %s: %w", path, err))
+			continue
+		}
+		thumbs[it.Slug] = img
+	}
+	return thumbs, errors.Join(errs...)
+}
+
+func renderMapThumbnail(site *SiteConfig, t *Track, bounds BoundingBox, tiles tileSource, dem *DEM) *image.NRGBA {
+	view := fitMapView(bounds, mapThumbWidth, mapThumbHeight)
+	img := imaging.New(view.Width, view.Height, mapPlain)
+
+	drawn := false
+	if tiles != nil {
+		drawn = drawMapTiles(img, view, tiles)
+		if drawn && site.Maps.Attribution != "" {
+			drawMapAttribution(img, site.Maps.Attribution)
+		}
+	}
+	if !drawn && dem != nil {
+		drawHillshade(img, view, dem)
+	}
+
+	// Track: white casing under the line, then start (and end) markers
+	casing := image.NewAlpha(img.Bounds())
+	line := image.NewAlpha(img.Bounds())
+	for _, seg := range t.Segments {
+		pts := make([][2]float64, len(seg))
+		for i, p := range seg {
+			x, y := mercatorPixel(p.Lat, p.Lon, view.Zoom)
+			pts[i] = [2]float64{x - view.Left, y - view.Top}
+		}
+		strokePolyline(casing, pts, 4.5)
+		strokePolyline(line, pts, 2.5)
+	}
+	draw.DrawMask(img, img.Bounds(), image.NewUniform(mapCasing), image.Point{}, casing, image.Point{}, draw.Over)
+	draw.DrawMask(img, img.Bounds(), image.NewUniform(mapLine), image.Point{}, line, image.Point{}, draw.Over)
+
+	points := t.Points()
+	if len(points) > 0 {
+		end := points[len(points)-1]
+		ex, ey := mercatorPixel(end.Lat, end.Lon, view.Zoom)
+		drawMarker(img, ex-view.Left, ey-view.Top, mapTrailEnd)
+		start := points[0]
+		sx, sy := mercatorPixel(start.Lat, start.Lon, view.Zoom)
+		drawMarker(img, sx-view.Left, sy-view.Top, mapLine)
+	}
+	return img
+}
+
+// drawMapTiles stitches the tiles covering the view. It reports false when
+// the source has none of them, so another background can be used.
+func drawMapTiles(img *image.NRGBA, view mapView, tiles tileSource) bool {
+	found := false
+	n := 1 << view.Zoom
+	x0, y0 := int(view.Left)/mapTileSize, int(view.Top)/mapTileSize
+	x1, y1 := (int(view.Left)+view.Width)/mapTileSize, (int(view.Top)+view.Height)/mapTileSize
+	for ty := y0; ty <= y1; ty++ {
+		for tx := x0; tx <= x1; tx++ {
+			if ty < 0 || ty >= n {
+				continue
+			}
+			tile := tiles.Tile(view.Zoom, (tx%n+n)%n, ty)
+			if tile == nil {
+				continue
+			}
+			if tile.Bounds().Dx() != mapTileSize {
+				tile = imaging.Resize(tile, mapTileSize, mapTileSize, imaging.Lanczos)
+			}
+			at := image.Pt(tx*mapTileSize-int(view.Left), ty*mapTileSize-int(view.Top))
+			draw.Draw(img, tile.Bounds().Sub(tile.Bounds().Min).Add(at), tile, tile.Bounds().Min, draw.Src)
+			found = true
+		}
+	}
+	return found
+}
+
+// drawHillshade shades the plain background from the DEM, lit from the
+// north-west. Pixels outside the DEM stay plain.
+func drawHillshade(img *image.NRGBA, view mapView, dem *DEM) {
+	w, h := view.Width, view.Height
+	ele := make([]float64, (w+2)*(h+2))
+	ok := make([]bool, len(ele))
+	for y := -1; y <= h; y++ {
+		for x := -1; x <= w; x++ {
+			lat, lon := mercatorLatLon(view.Left+float64(x)+0.5, view.Top+float64(y)+0.5, view.Zoom)
+			i := (y+1)*(w+2) + x + 1
+			ele[i], ok[i] = dem.Elevation(lat, lon)
+		}
+	}
+
+	lat, _ := mercatorLatLon(view.Left+float64(w)/2, view.Top+float64(h)/2, view.Zoom)
+	cell := 156543.03392 * math.Cos(lat*math.Pi/180) / math.Exp2(float64(view.Zoom)) // meters per pixel
+	const (
+		azimuth      = 315 * math.Pi / 180 // Clockwise from north
+		altitude     = 45 * math.Pi / 180
+		exaggeration = 2.0
+	)
+	lightX := math.Cos(altitude) * math.Sin(azimuth)
+	lightY := -math.Cos(altitude) * math.Cos(azimuth)
+	lightZ := math.Sin(altitude)
+
+	at := func(x, y int) (float64, bool) {
+		i := (y+1)*(w+2) + x + 1
+		return ele[i], ok[i]
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			l, ok1 := at(x-1, y)
+			r, ok2 := at(x+1, y)
+			u, ok3 := at(x, y-1)
+			d, ok4 := at(x, y+1)
+			if !ok1 || !ok2 || !ok3 || !ok4 {
+				continue
+			}
+			// Surface normal in (east, south, up) dotted with the light direction
+			dzdx := (r - l) / (2 * cell) * exaggeration
+			dzdy := (d - u) / (2 * cell) * exaggeration
+			norm := math.Sqrt(dzdx*dzdx + dzdy*dzdy + 1)
+			shade := (-dzdx*lightX - dzdy*lightY + lightZ) / norm
+			shade = math.Max(0, math.Min(1, shade))
+
+			f := 0.6 + 0.4*shade
+			img.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(float64(mapPlain.R) * f),
+				G: uint8(float64(mapPlain.G) * f),
+				B: uint8(float64(mapPlain.B) * f),
+				A: 0xff,
+			})
+		}
+	}
+}
+
+// strokePolyline paints an antialiased round-capped line of the given radius
+// into the mask by stamping discs at sub-pixel steps.
+func strokePolyline(mask *image.Alpha, pts [][2]float64, radius float64) {
+	for i := range pts {
+		if i == 0 {
+			stampDisc(mask, pts[0][0], pts[0][1], radius)
+			continue
+		}
+		ax, ay := pts[i-1][0], pts[i-1][1]
+		bx, by := pts[i][0], pts[i][1]
+		steps := int(math.Ceil(math.Hypot(bx-ax, by-ay) * 2))
+		for s := 1; s <= steps; s++ {
+			f := float64(s) / float64(steps)
+			stampDisc(mask, ax+(bx-ax)*f, ay+(by-ay)*f, radius)
+		}
+	}
+}
+
+func stampDisc(mask *image.Alpha, cx, cy, radius float64) {
+	b := mask.Bounds()
+	for y := int(cy - radius - 1); y <= int(cy+radius+1); y++ {
+		for x := int(cx - radius - 1); x <= int(cx+radius+1); x++ {
+			if !image.Pt(x, y).In(b) {
+				continue
+			}
+			d := math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy)
+			coverage := math.Max(0, math.Min(1, radius+0.5-d))
+			a := uint8(coverage * 0xff)
+			i := mask.PixOffset(x, y)
+			if a > mask.Pix[i] {
+				mask.Pix[i] = a
+			}
+		}
+	}
+}
+
+func drawMarker(img *image.NRGBA, x, y float64, c color.NRGBA) {
+	outer := image.NewAlpha(img.Bounds())
+	stampDisc(outer, x, y, 8)
+	draw.DrawMask(img, img.Bounds(), image.NewUniform(mapCasing), image.Point{}, outer, image.Point{}, draw.Over)
+	inner := image.NewAlpha(img.Bounds())
+	stampDisc(inner, x, y, 5.5)
+	draw.DrawMask(img, img.Bounds(), image.NewUniform(c), image.Point{}, inner, image.Point{}, draw.Over)
+}
+
+// drawMapAttribution credits the tile provider in the bottom right corner.
+func drawMapAttribution(img *image.NRGBA, text string) {
+	f, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: 12, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return
+	}
+	w := font.MeasureString(face, text).Ceil()
+	b := img.Bounds()
+	box := image.Rect(b.Max.X-w-10, b.Max.Y-18, b.Max.X, b.Max.Y)
+	draw.Draw(img, box, image.NewUniform(color.NRGBA{0xff, 0xff, 0xff, 0xb3}), image.Point{}, draw.Over)
+	d := font.Drawer{Dst: img, Src: image.NewUniform(color.NRGBA{0x33, 0x33, 0x33, 0xff}), Face: face, Dot: fixed.P(box.Min.X+5, b.Max.Y-5)}
+	d.DrawString(text)
+}
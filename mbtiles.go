@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Offline Tiles
+//
+// Raster tiles covering each itinerary are copied from a local MBTiles file
+// (no network) into dist/static/tiles/<z>/<x>/<y>.<ext>, so the detail page
+// maps keep working without coverage once cached by the service worker.
+
+type TilesConfig struct {
+	MBTiles string  `toml:"mbtiles"`  // Local raster MBTiles file, empty disables offline tiles
+	MinZoom int     `toml:"min_zoom"` // Zoom levels packaged for each itinerary
+	MaxZoom int     `toml:"max_zoom"`
+	Padding float64 `toml:"padding"` // Meters added around each track
+	Format  string  `toml:"-"`       // Tile format once packaged, empty when there are no offline tiles
+}
+
+type tileKey struct{ Z, X, Y int }
+
+// MBTiles reads the tiles of an MBTiles 1.x file: either a plain "tiles"
+// table or the deduplicated "map" + "images" layout behind a "tiles" view.
+type MBTiles struct {
+	db     *sqliteDB
+	Format string // png, jpg or webp
+
+	tiles      sqliteTable // Plain layout
+	mapTable   sqliteTable // Deduplicated layout
+	imageTable sqliteTable
+}
+
+func openMBTiles(path string) (*MBTiles, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &MBTiles{db: db, Format: "png"}
+	if err := m.init(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+func (m *MBTiles) Close() error {
+	return m.db.Close()
+}
+
+func (m *MBTiles) init() error {
+	schema, err := m.db.schema()
+	if err != nil {
+		return err
+	}
+
+	if meta, ok := schema["metadata"]; ok && meta.Type == "table" {
+		name, value := columnIndex(meta, "name"), columnIndex(meta, "value")
+		err := m.db.scanTable(meta.RootPage, func(row *sqliteRow) error {
+			vals, err := row.Values(-1)
+			if err != nil || name < 0 || value < 0 || len(vals) <= max(name, value) {
+				return err
+			}
+			if k, _ := vals[name].(string); k == "format" {
+				m.Format, _ = vals[value].(string)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	switch m.Format {
+	case "png", "jpg", "webp":
+	case "jpeg":
+		m.Format = "jpg"
+	default:
+		return fmt.Errorf("unsupported tile format %q (only raster tiles)", m.Format)
+	}
+
+	if t, ok := schema["tiles"]; ok && t.Type == "table" {
+		m.tiles = t
+		return nil
+	}
+	mt, ok1 := schema["map"]
+	it, ok2 := schema["images"]
+	if ok1 && ok2 && mt.Type == "table" && it.Type == "table" {
+		m.mapTable, m.imageTable = mt, it
+		return nil
+	}
+	return errors.New("no tiles table")
+}
+
+func columnIndex(t sqliteTable, name string) int {
+	for i, c := range t.Columns {
+		if c == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Fetch returns the data of every available tile in want (XYZ scheme). The
+// tables are scanned once; blobs are only read for wanted tiles.
+func (m *MBTiles) Fetch(want map[tileKey]bool) (map[tileKey][]byte, error) {
+	out := make(map[tileKey][]byte)
+	if m.tiles.RootPage != 0 {
+		t := m.tiles
+		z, x, y, data := columnIndex(t, "zoom_level"), columnIndex(t, "tile_column"), columnIndex(t, "tile_row"), columnIndex(t, "tile_data")
+		if min(z, x, y, data) < 0 {
+			return nil, errors.New("tiles: unexpected columns")
+		}
+		err := m.db.scanTable(t.RootPage, func(row *sqliteRow) error {
+			key, ok := m.rowKey(row, z, x, y)
+			if !ok || !want[key] {
+				return nil
+			}
+			vals, err := row.Values(data + 1)
+			if err != nil {
+				return err
+			}
+			if b, ok := vals[data].([]byte); ok {
+				out[key] = b
+			}
+			return nil
+		})
+		return out, err
+	}
+
+	// Deduplicated layout: map the wanted tiles to image ids, then read images
+	t := m.mapTable
+	z, x, y, id := columnIndex(t, "zoom_level"), columnIndex(t, "tile_column"), columnIndex(t, "tile_row"), columnIndex(t, "tile_id")
+	if min(z, x, y, id) < 0 {
+		return nil, errors.New("map: unexpected columns")
+	}
+	byID := make(map[string][]tileKey)
+	err := m.db.scanTable(t.RootPage, func(row *sqliteRow) error {
+		key, ok := m.rowKey(row, z, x, y)
+		if !ok || !want[key] {
+			return nil
+		}
+		vals, err := row.Values(id + 1)
+		if err != nil {
+			return err
+		}
+		tid := fmt.Sprint(vals[id])
+		byID[tid] = append(byID[tid], key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	it := m.imageTable
+	data, iid := columnIndex(it, "tile_data"), columnIndex(it, "tile_id")
+	if min(data, iid) < 0 {
+		return nil, errors.New("images: unexpected columns")
+	}
+	err = m.db.scanTable(it.RootPage, func(row *sqliteRow) error {
+		vals, err := row.Values(max(data, iid) + 1)
+		if err != nil {
+			return err
+		}
+		keys := byID[fmt.Sprint(vals[iid])]
+		if len(keys) == 0 {
+			return nil
+		}
+		if b, ok := vals[data].([]byte); ok {
+			for _, key := range keys {
+				out[key] = b
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+// rowKey reads the tile coordinates of a row, converting the TMS row to XYZ.
+func (m *MBTiles) rowKey(row *sqliteRow, z, x, y int) (tileKey, bool) {
+	vals, err := row.Values(max(z, x, y) + 1)
+	if err != nil {
+		return tileKey{}, false
+	}
+	zv, ok1 := vals[z].(int64)
+	xv, ok2 := vals[x].(int64)
+	yv, ok3 := vals[y].(int64)
+	if !ok1 || !ok2 || !ok3 {
+		return tileKey{}, false
+	}
+	return tileKey{Z: int(zv), X: int(xv), Y: (1 << zv) - 1 - int(yv)}, true
+}
+
+// tileRange lists the tiles covering the bounds at zoom z.
+func tileRange(b BoundingBox, z int) []tileKey {
+	x0, y0 := mercatorPixel(b.MaxLat, b.MinLon, z)
+	x1, y1 := mercatorPixel(b.MinLat, b.MaxLon, z)
+	n := 1 << z
+	clamp := func(v float64) int {
+		return max(0, min(n-1, int(math.Floor(v/mapTileSize))))
+	}
+	var keys []tileKey
+	for ty := clamp(y0); ty <= clamp(y1); ty++ {
+		for tx := clamp(x0); tx <= clamp(x1); tx++ {
+			keys = append(keys, tileKey{Z: z, X: tx, Y: ty})
+		}
+	}
+	return keys
+}
+
+// padBounds grows the bounds by the given distance in meters.
+func padBounds(b BoundingBox, meters float64) BoundingBox {
+	dLat := meters / 111320
+	dLon := meters / (111320 * math.Cos((b.MinLat+b.MaxLat)/2*math.Pi/180))
+	return BoundingBox{MinLat: b.MinLat - dLat, MinLon: b.MinLon - dLon, MaxLat: b.MaxLat + dLat, MaxLon: b.MaxLon + dLon}
+}
+
+// writeOfflineTiles packages the tiles around every track and records the
+// web paths on each itinerary (for the service worker precache).
+func writeOfflineTiles(site *SiteConfig, its []ItineraryFile) error {
+	if site.Tiles.MBTiles == "" {
+		return nil
+	}
+	mb, err := openMBTiles(site.Tiles.MBTiles)
+	if err != nil {
+		return err
+	}
+	defer mb.Close()
+
+	want := make(map[tileKey]bool)
+	for i := range its {
+		if its[i].Track == nil {
+			continue
+		}
+		bounds := padBounds(its[i].Bounds, site.Tiles.Padding)
+		for z := site.Tiles.MinZoom; z <= site.Tiles.MaxZoom; z++ {
+			for _, key := range tileRange(bounds, z) {
+				want[key] = true
+			}
+		}
+	}
+
+	data, err := mb.Fetch(want)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", site.Tiles.MBTiles, err)
+	}
+	var errs []error
+	for key, b := range data {
+		path := filepath.Join("dist", filepath.FromSlash(tilePath(key, mb.Format)))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("creating tile dir: %w", err)
+		}
+		if err := writeFileAtomic(path, b); err != nil {
+			errs = append(errs, fmt.Errorf("writing %s: %w", path, err))
+		}
+	}
+
+	// Only list tiles that exist, the MBTiles file may not cover everything
+	for i := range its {
+		if its[i].Track == nil {
+			continue
+		}
+		bounds := padBounds(its[i].Bounds, site.Tiles.Padding)
+		for z := site.Tiles.MinZoom; z <= site.Tiles.MaxZoom; z++ {
+			for _, key := range tileRange(bounds, z) {
+				if _, ok := data[key]; ok {
+					its[i].OfflineTiles = append(its[i].OfflineTiles, tilePath(key, mb.Format))
+				}
+			}
+		}
+	}
+	site.Tiles.Format = mb.Format
+	log.Printf("Offline tiles: %d of %d written from %s", len(data), len(want), site.Tiles.MBTiles)
+	return errors.Join(errs...)
+}
+
+func tilePath(key tileKey, format string) string {
+	return fmt.Sprintf("/static/tiles/%d/%d/%d.%s", key.Z, key.X, key.Y, format)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// The fixtures were written by SQLite with 512-byte pages, so the tables span
+// several leaf pages under an interior page and the 5000-byte blob needs an
+// overflow chain:
+//
+//   - tiles_plain.mbtiles: a "tiles" table with zoom 8 columns 0-15, rows
+//     0-15, and one large tile at 12/2000/3000 (TMS)
+//   - tiles_dedup.mbtiles: "map" and "images" tables behind a "tiles" view,
+//     the same zoom 8 tiles, columns 0-7 sharing the large "sea" image
+
+func fixtureSmallTile(z, x, tmsY int) []byte {
+	return bytes.Repeat([]byte(fmt.Sprintf("%d/%d/%d;", z, x, tmsY)), 3)
+}
+
+func fixtureLargeTile() []byte {
+	b := make([]byte, 5000)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+// xyz converts a TMS tile to the XYZ key Fetch uses.
+func xyz(z, x, tmsY int) tileKey {
+	return tileKey{Z: z, X: x, Y: 1<<z - 1 - tmsY}
+}
+
+func TestSQLiteScanTable(t *testing.T) {
+	db, err := openSQLite("testdata/tiles_plain.mbtiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.pageSize != 512 {
+		t.Fatalf("page size = %d, want 512", db.pageSize)
+	}
+	schema, err := db.schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tiles := schema["tiles"]
+	if got := fmt.Sprint(tiles.Columns); got != "[zoom_level tile_column tile_row tile_data]" {
+		t.Fatalf("tiles columns = %s", got)
+	}
+
+	var rows int
+	var last int64
+	err = db.scanTable(tiles.RootPage, func(row *sqliteRow) error {
+		if row.RowID <= last {
+			return fmt.Errorf("rowid %d after %d", row.RowID, last)
+		}
+		last = row.RowID
+		rows++
+		vals, err := row.Values(-1)
+		if err != nil {
+			return err
+		}
+		z, x, y := int(vals[0].(int64)), int(vals[1].(int64)), int(vals[2].(int64))
+		want := fixtureSmallTile(z, x, y)
+		if z == 12 {
+			want = fixtureLargeTile()
+		}
+		if !bytes.Equal(vals[3].([]byte), want) {
+			return fmt.Errorf("row %d: wrong tile_data for %d/%d/%d", row.RowID, z, x, y)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 257 {
+		t.Errorf("scanned %d rows, want 257", rows)
+	}
+}
+
+func TestMBTilesFetch(t *testing.T) {
+	for _, path := range []string{"testdata/tiles_plain.mbtiles", "testdata/tiles_dedup.mbtiles"} {
+		t.Run(path, func(t *testing.T) {
+			m, err := openMBTiles(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.Close()
+			if m.Format != "png" {
+				t.Errorf("format = %q, want png", m.Format)
+			}
+
+			want := map[tileKey][]byte{
+				xyz(8, 9, 0):   fixtureSmallTile(8, 9, 0),
+				xyz(8, 15, 15): fixtureSmallTile(8, 15, 15),
+			}
+			if path == "testdata/tiles_plain.mbtiles" {
+				want[xyz(12, 2000, 3000)] = fixtureLargeTile()
+			} else {
+				want[xyz(8, 0, 0)] = fixtureLargeTile()
+				want[xyz(8, 7, 12)] = fixtureLargeTile()
+			}
+			keys := map[tileKey]bool{xyz(8, 40, 40): true} // Not in the file
+			for k := range want {
+				keys[k] = true
+			}
+
+			got, err := m.Fetch(keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(want) {
+				t.Errorf("fetched %d tiles, want %d", len(got), len(want))
+			}
+			for k, b := range want {
+				if !bytes.Equal(got[k], b) {
+					t.Errorf("tile %v: got %d bytes, want %d", k, len(got[k]), len(b))
+				}
+			}
+		})
+	}
+}
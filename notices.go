@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Trail Notices
+//
+// Itineraries can carry time-boxed notices ("landslide on the lower section,
+// path closed until further notice"). Only notices active at build time are
+// rendered, so an expired notice disappears on the next (scheduled) build.
+
+const (
+	noticeInfo    = "info"
+	noticeWarning = "warning"
+	noticeClosure = "closure"
+)
+
+type ItineraryNotice struct {
+	Severity string         `toml:"severity"`  // info, warning or closure
+	Start    toml.LocalDate `toml:"start"`     // First day the notice is shown
+	End      toml.LocalDate `toml:"end"`       // Last day the notice is shown, omit for "until further notice"
+	SiteWide bool           `toml:"site_wide"` // Also show it on the homepage
+	It       NoticeLocale   `toml:"it"`
+	En       NoticeLocale   `toml:"en"`
+}
+
+type NoticeLocale struct {
+	Text string `toml:"text"`
+}
+
+type RenderNotice struct {
+	Severity       string
+	Text           string
+	Start          string
+	End            string // Empty when open-ended
+	SiteWide       bool
+	ItineraryTitle string
+	ItineraryUrl   string
+}
+
+// isActive reports whether the notice should be shown at the given time.
+// Dates are whole days in the site timezone, both ends inclusive.
+func (n ItineraryNotice) isActive(now time.Time, loc *time.Location) bool {
+	if n.Start != (toml.LocalDate{}) && now.Before(n.Start.AsTime(loc)) {
+		return false
+	}
+	if n.End != (toml.LocalDate{}) && !now.Before(n.End.AsTime(loc).AddDate(0, 0, 1)) {
+		return false
+	}
+	return true
+}
+
+// activeNotices returns the localized notices of an itinerary that are
+// active at the given time.
+func activeNotices(it ItineraryFile, locale string, now time.Time, loc *time.Location) []RenderNotice {
+	var out []RenderNotice
+	for _, n := range it.Notices {
+		if !n.isActive(now, loc) {
+			continue
+		}
+		text := n.It.Text
+		title := it.It.Title
+		if locale == "en" {
+			text = n.En.Text
+			title = it.En.Title
+		}
+
+		rn := RenderNotice{
+			Severity:       n.Severity,
+			Text:           text,
+			SiteWide:       n.SiteWide,
+			ItineraryTitle: title,
+			ItineraryUrl:   "/itineraries/" + it.Slug + ".html",
+		}
+		if n.Start != (toml.LocalDate{}) {
+			rn.Start = formatDate(locale, n.Start.AsTime(loc))
+		}
+		if n.End != (toml.LocalDate{}) {
+			rn.End = formatDate(locale, n.End.AsTime(loc))
+		}
+		out = append(out, rn)
+	}
+	return out
+}
+
+func validateNotices(it *ItineraryFile) {
+	for i := range it.Notices {
+		switch it.Notices[i].Severity {
+		case noticeInfo, noticeWarning, noticeClosure:
+		default:
+			log.Printf("WARNING: itinerary %s: unknown notice severity %q, using %q", it.Slug, it.Notices[i].Severity, noticeInfo)
+			it.Notices[i].Severity = noticeInfo
+		}
+	}
+}
+
+var monthsIt = []string{"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno",
+	"luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"}
+
+// formatDate renders a date the way each locale writes it:
+// "16 agosto 2026" / "August 16, 2026".
+func formatDate(locale string, t time.Time) string {
+	if locale == "it" {
+		return fmt.Sprintf("%d %s %d", t.Day(), monthsIt[t.Month()-1], t.Year())
+	}
+	return t.Format("January 2, 2006")
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Open Graph Images
+//
+// Each itinerary gets a 1200x630 share image per locale: the itinerary photo
+// with a dark gradient, the title, distance and elevation gain, the same
+// difficulty badge as the list cards and the route map thumbnail. Written to
+// dist/static/og/[en/]<slug>.jpg.
+
+const (
+	ogWidth  = 1200
+	ogHeight = 630
+	ogMargin = 60
+)
+
+var (
+	ogBackground = color.RGBA{0x10, 0x22, 0x10, 0xff} // background-dark
+	ogBadgeText  = map[string]color.RGBA{
+		"easy":   {0x15, 0x80, 0x3d, 0xff}, // green-700
+		"medium": {0xa1, 0x62, 0x07, 0xff}, // yellow-700
+		"hard":   {0xb9, 0x1c, 0x1c, 0xff}, // red-700
+	}
+)
+
+// ogImagePath returns the web path of an itinerary share image.
+func ogImagePath(locale string, slug string) string {
+	if locale == "en" {
+		return "/static/og/en/" + slug + ".jpg"
+	}
+	return "/static/og/" + slug + ".jpg"
+}
+
+type ogFaces struct {
+	title, stats, badge, brand font.Face
+}
+
+func loadOgFaces() (*ogFaces, error) {
+	bold, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		return nil, err
+	}
+	regular, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, err
+	}
+	face := func(f *opentype.Font, size float64) font.Face {
+		ff, _ := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+		return ff
+	}
+	return &ogFaces{
+		title: face(bold, 68),
+		stats: face(regular, 38),
+		badge: face(bold, 28),
+		brand: face(bold, 30),
+	}, nil
+}
+
+func writeOgImages(indexData *IndexFile, its []ItineraryFile, mapThumbs map[string]image.Image) error {
+	faces, err := loadOgFaces()
+	if err != nil {
+		return fmt.Errorf("loading fonts: %w", err)
+	}
+
+	var errs []error
+	for _, it := range its {
+		// Like renderLocale: without a track there is no page to share
+		if it.GpxFile == "" {
+			continue
+		}
+
+		// The background is shared by both locales
+		bg := ogBackgroundImage(it.Image)
+
+		for _, locale := range []string{"it", "en"} {
+			l, pl := it.It, indexData.It.ItineraryPage
+			if locale == "en" {
+				l, pl = it.En, indexData.En.ItineraryPage
+			}
+			img := ogCompose(bg, faces, l.Title, ogStats(it), ogDifficultyLabel(pl, it.Difficulty), it.Difficulty, mapThumbs[it.Slug])
+
+			path := filepath.Join("dist", filepath.FromSlash(ogImagePath(locale, it.Slug)))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return fmt.Errorf("creating og dir: %w", err)
+			}
+			if err := saveImage(img, path, imaging.JPEGQuality(85)); err != nil {
+				errs = append(errs, fmt.Errorf("writing %s: %w", path, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ogBackgroundImage crops the itinerary photo to the share size, falling back
+// to the site background color.
+func ogBackgroundImage(webPath string) image.Image {
+	if webPath != "" {
+		src, err := imaging.Open(staticFsPath(webPath), imaging.AutoOrientation(true))
+		if err == nil {
+			return imaging.Fill(src, ogWidth, ogHeight, imaging.Center, imaging.Lanczos)
+		}
+		log.Printf("Warning: og image background %s: %v", webPath, err)
+	}
+	return imaging.New(ogWidth, ogHeight, ogBackground)
+}
+
+func ogStats(it ItineraryFile) string {
+	var parts []string
+	if it.DistanceKM > 0 {
+		parts = append(parts, fmt.Sprintf("%.1f km", it.DistanceKM))
+	}
+	if it.ElevationGain > 0 {
+		parts = append(parts, fmt.Sprintf("↑ %d m", it.ElevationGain))
+	}
+	if it.Duration != "" {
+		parts = append(parts, it.Duration)
+	}
+	return strings.Join(parts, "  ·  ")
+}
+
+func ogDifficultyLabel(l ItineraryPageLocale, difficulty string) string {
+	switch difficulty {
+	case "easy":
+		return l.DifficultyEasy
+	case "medium":
+		return l.DifficultyMedium
+	case "hard":
+		return l.DifficultyHard
+	}
+	return ""
+}
+
+func ogCompose(bg image.Image, faces *ogFaces, title, stats, badge, difficulty string, mapThumb image.Image) *image.NRGBA {
+	img := imaging.Clone(bg)
+
+	// Darken the lower part so white text stays readable on any photo
+	for y := ogHeight / 3; y < ogHeight; y++ {
+		a := uint8(210 * (y - ogHeight/3) / (ogHeight - ogHeight/3))
+		draw.Draw(img, image.Rect(0, y, ogWidth, y+1), image.NewUniform(color.NRGBA{0, 0, 0, a}), image.Point{}, draw.Over)
+	}
+
+	white := image.NewUniform(color.White)
+
+	// Title, wrapped to at most two lines from the bottom up
+	lines := ogWrap(faces.title, title, ogWidth-2*ogMargin, 2)
+	y := ogHeight - ogMargin - 70
+	for i := len(lines) - 1; i >= 0; i-- {
+		ogDrawText(img, faces.title, white, ogMargin, y-(len(lines)-1-i)*80, lines[i])
+	}
+	ogDrawText(img, faces.stats, image.NewUniform(color.RGBA{0xe5, 0xe7, 0xeb, 0xff}), ogMargin, ogHeight-ogMargin, stats)
+
+	// Difficulty badge, top left, styled like the list cards
+	if badge != "" {
+		label := strings.ToUpper(badge)
+		w := font.MeasureString(faces.badge, label).Ceil()
+		r := image.Rect(ogMargin, ogMargin, ogMargin+w+40, ogMargin+56)
+		ogFillRoundedRect(img, r, 14, color.NRGBA{0xff, 0xff, 0xff, 0xe6})
+		c, ok := ogBadgeText[difficulty]
+		if !ok {
+			c = ogBadgeText["hard"]
+		}
+		ogDrawText(img, faces.badge, image.NewUniform(c), r.Min.X+20, r.Min.Y+39, label)
+	}
+
+	// Route map inset, top right
+	if mapThumb != nil {
+		inset := imaging.Resize(mapThumb, 300, 0, imaging.Lanczos)
+		r := inset.Bounds().Add(image.Pt(ogWidth-ogMargin-inset.Bounds().Dx(), ogMargin))
+		ogFillRoundedRect(img, r.Inset(-5), 12, color.NRGBA{0xff, 0xff, 0xff, 0xff})
+		draw.Draw(img, r, inset, image.Point{}, draw.Src)
+	}
+
+	// Brand, bottom right
+	brand := "bruggi.it"
+	w := font.MeasureString(faces.brand, brand).Ceil()
+	ogDrawText(img, faces.brand, image.NewUniform(color.RGBA{0x11, 0xd4, 0x11, 0xff}), ogWidth-ogMargin-w, ogHeight-ogMargin, brand)
+
+	return img
+}
+
+func ogDrawText(dst draw.Image, face font.Face, src image.Image, x, y int, text string) {
+	d := font.Drawer{Dst: dst, Src: src, Face: face, Dot: fixed.P(x, y)}
+	d.DrawString(text)
+}
+
+// ogWrap splits text into lines no wider than maxWidth, truncating with an
+// ellipsis past maxLines.
+func ogWrap(face font.Face, text string, maxWidth int, maxLines int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		candidate := strings.TrimSpace(line + " " + word)
+		if line != "" && font.MeasureString(face, candidate).Ceil() > maxWidth {
+			lines = append(lines, line)
+			line = word
+			continue
+		}
+		line = candidate
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		last := lines[maxLines-1]
+		for last != "" && font.MeasureString(face, last+"…").Ceil() > maxWidth {
+			last = strings.TrimSpace(last[:strings.LastIndex(last, " ")+1])
+		}
+		lines[maxLines-1] = last + "…"
+	}
+	return lines
+}
+
+// ogFillRoundedRect blends c over r with rounded corners: a mask of the
+// shape, filled a row span at a time, and a single draw.
+func ogFillRoundedRect(img *image.NRGBA, r image.Rectangle, radius int, c color.NRGBA) {
+	mask := image.NewAlpha(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		// Rows within radius of the top or bottom are inset by the corner arc
+		dy := 0
+		switch {
+		case y < r.Min.Y+radius:
+			dy = r.Min.Y + radius - y
+		case y >= r.Max.Y-radius:
+			dy = y - (r.Max.Y - radius - 1)
+		}
+		inset := radius - int(math.Sqrt(float64(radius*radius-dy*dy)))
+		if dy == 0 {
+			inset = 0
+		}
+		if r.Min.X+inset >= r.Max.X-inset {
+			continue
+		}
+		row := mask.Pix[mask.PixOffset(r.Min.X+inset, y):mask.PixOffset(r.Max.X-inset, y)]
+		for i := range row {
+			row[i] = 0xff
+		}
+	}
+	draw.DrawMask(img, r, image.NewUniform(c), image.Point{}, mask, r.Min, draw.Over)
+}
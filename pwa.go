@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/flosch/pongo2/v6"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Progressive Web App
+//
+// A manifest per locale makes the site installable and dist/sw.js keeps it
+// usable without coverage. The service worker precache lists are computed on
+// every build from the files actually written to dist: a shell of pages and
+// assets cached on install, plus one list per itinerary (both locale pages,
+// GPX, photos, map thumbnail and offline tiles) cached when a hiker saves the
+// trail from its detail page.
+
+var pwaIconSizes = []int{192, 512}
+
+type webManifest struct {
+	ID              string            `json:"id"`
+	Name            string            `json:"name"`
+	ShortName       string            `json:"short_name"`
+	Description     string            `json:"description,omitempty"`
+	Lang            string            `json:"lang"`
+	StartURL        string            `json:"start_url"`
+	Scope           string            `json:"scope"`
+	Display         string            `json:"display"`
+	BackgroundColor string            `json:"background_color"`
+	ThemeColor      string            `json:"theme_color"`
+	Icons           []webManifestIcon `json:"icons"`
+}
+
+type webManifestIcon struct {
+	Src     string `json:"src"`
+	Sizes   string `json:"sizes"`
+	Type    string `json:"type"`
+	Purpose string `json:"purpose"`
+}
+
+// precacheList is a group of URLs cached together. Version is a hash of the
+// URLs and file contents, so any change in the group invalidates its cache.
+type precacheList struct {
+	Version string   `json:"version"`
+	URLs    []string `json:"urls"`
+}
+
+func pwaIconPath(size int) string {
+	return "/static/icons/icon-" + strconv.Itoa(size) + ".png"
+}
+
+// writePWA writes the manifests, icons and service worker. It runs last, the
+// precache lists only include files present in dist.
+func writePWA(indexData *IndexFile, its []ItineraryFile, pages []SitemapPage) {
+	if err := writePwaIcons(); err != nil {
+		log.Printf("Error writing app icons: %v", err)
+	}
+	for _, locale := range []string{"it", "en"} {
+		if err := writeManifest(indexData, locale); err != nil {
+			log.Printf("Error writing %s manifest: %v", locale, err)
+		}
+	}
+
+	shell, trails := precacheLists(its, pages)
+
+	shellJSON, err := json.Marshal(shell)
+	if err != nil {
+		log.Panic(err)
+	}
+	trailsJSON, err := json.Marshal(trails)
+	if err != nil {
+		log.Panic(err)
+	}
+	tpl := pongo2.Must(pongo2.FromFile("templates/sw.js"))
+	ctx := pongo2.Context{
+		"shell":  string(shellJSON),
+		"trails": string(trailsJSON),
+	}
+	if err := renderToFile(tpl, ctx, "dist/sw.js"); err != nil {
+		log.Panic(err)
+	}
+
+	n := 0
+	for _, t := range trails {
+		n += len(t.URLs)
+	}
+	log.Printf("Service worker: %d shell files, %d itineraries (%d files)", len(shell.URLs), len(trails), n)
+}
+
+func writeManifest(indexData *IndexFile, locale string) error {
+	l, start, path := indexData.It, "/", "dist/manifest.webmanifest"
+	if locale == "en" {
+		l, start, path = indexData.En, "/en/", "dist/en/manifest.webmanifest"
+	}
+	m := webManifest{
+		ID:              start,
+		Name:            "Bruggi",
+		ShortName:       "Bruggi",
+		Description:     l.Hero.Subtitle,
+		Lang:            locale,
+		StartURL:        start,
+		Scope:           "/",
+		Display:         "standalone",
+		BackgroundColor: "#f6f8f6", // background-light
+		ThemeColor:      "#11d411", // primary
+	}
+	for _, size := range pwaIconSizes {
+		m.Icons = append(m.Icons, webManifestIcon{
+			Src:     pwaIconPath(size),
+			Sizes:   fmt.Sprintf("%dx%d", size, size),
+			Type:    "image/png",
+			Purpose: "any maskable",
+		})
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+// writePwaIcons draws the app icons: a bold "B" on the primary color. The
+// glyph stays inside the central 80% so the icons also work as maskable.
+func writePwaIcons() error {
+	f, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll("dist/static/icons", 0755); err != nil {
+		return err
+	}
+	for _, size := range pwaIconSizes {
+		face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: float64(size) * 0.55, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return err
+		}
+		img := imaging.New(size, size, color.NRGBA{0x11, 0xd4, 0x11, 0xff})
+		bounds, advance := font.BoundString(face, "B")
+		x := (fixed.I(size) - advance) / 2
+		y := (fixed.I(size) - (bounds.Max.Y - bounds.Min.Y)) / 2
+		d := font.Drawer{
+			Dst:  img,
+			Src:  image.NewUniform(color.NRGBA{0x11, 0x18, 0x11, 0xff}),
+			Face: face,
+			Dot:  fixed.Point26_6{X: x, Y: y - bounds.Min.Y},
+		}
+		d.DrawString("B")
+		face.Close()
+
+		path := filepath.Join("dist", filepath.FromSlash(pwaIconPath(size)))
+		if err := saveImage(img, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// precacheLists builds the shell (every page but itinerary details, styles,
+// scripts, fonts, icons, manifests) and the per-itinerary lists, keyed by
+// slug. Itineraries without a rendered detail page are left out.
+func precacheLists(its []ItineraryFile, pages []SitemapPage) (precacheList, map[string]precacheList) {
+	details := make(map[string]bool)
+	for _, it := range its {
+		details["/itineraries/"+it.Slug+".html"] = true
+	}
+
+	var shell []string
+	seen := make(map[string]bool)
+	for _, p := range pages {
+		if details[p.Path] || seen[p.Path] {
+			continue
+		}
+		seen[p.Path] = true
+		shell = append(shell, p.Path, "/en"+p.Path)
+	}
+	shell = append(shell, "/manifest.webmanifest", "/en/manifest.webmanifest")
+	for _, dir := range []string{"css", "js", "fonts", "icons"} {
+		root := filepath.Join("dist", "static", dir)
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, _ := filepath.Rel("dist", path)
+			shell = append(shell, "/"+filepath.ToSlash(rel))
+			return nil
+		})
+	}
+
+	trails := make(map[string]precacheList)
+	for _, it := range its {
+		page := "/itineraries/" + it.Slug + ".html"
+		if _, err := os.Stat(precacheFsPath(page)); err != nil {
+			continue
+		}
+		urls := []string{page, "/en" + page, it.PublishedGpx, it.Image}
+		if it.Track != nil {
+			urls = append(urls, mapThumbPath(it.Slug))
+		}
+		for _, img := range it.ProcessedGallery {
+			urls = append(urls, img.Thumbnail)
+		}
+		urls = append(urls, it.OfflineTiles...)
+		trails[it.Slug] = newPrecacheList(urls)
+	}
+	return newPrecacheList(shell), trails
+}
+
+// newPrecacheList drops duplicates and URLs without a file in dist, and
+// hashes the rest.
+func newPrecacheList(urls []string) precacheList {
+	h := sha256.New()
+	l := precacheList{URLs: []string{}}
+	seen := make(map[string]bool)
+	for _, u := range urls {
+		if u == "" || seen[u] {
+			continue
+		}
+		seen[u] = true
+		b, err := os.ReadFile(precacheFsPath(u))
+		if err != nil {
+			continue
+		}
+		h.Write([]byte(u))
+		h.Write(b)
+		l.URLs = append(l.URLs, u)
+	}
+	l.Version = hex.EncodeToString(h.Sum(nil))[:12]
+	return l
+}
+
+// precacheFsPath maps a site URL to its file in dist.
+func precacheFsPath(url string) string {
+	if url == "/" || strings.HasSuffix(url, "/") {
+		url += "index.html"
+	}
+	return filepath.Join("dist", filepath.FromSlash(url))
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockSite waits for the site lock and returns its release. Every command
+// that writes static/ or dist/ (the build, webcam and weather updates, the
+// daemon's ingests) holds it while it does, so a build never runs halfway
+// through an update and two updates never interleave their read, merge and
+// write of the same files. The lock is an flock on siteLockPath: separate
+// processes and separate goroutines of one process exclude each other, and
+// it is released when the process dies.
+func lockSite() (func(), error) {
+	f, err := os.OpenFile(siteLockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking %s: %w", siteLockPath, err)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
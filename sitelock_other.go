@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// lockSite is a no-op where flock is not available: run one command at a
+// time there.
+func lockSite() (func(), error) {
+	return func() {}, nil
+}
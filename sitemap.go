@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/xml"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sitemap and robots.txt
+//
+// renderLocale reports every page it writes as a locale-neutral path ("/",
+// "/itineraries/pineta.html"); each path becomes one <url> per locale with
+// hreflang alternates pointing at the other mirror.
+
+type SitemapPage struct {
+	Path    string    // Relative to the locale root, as passed to computeAlternateUrl
+	LastMod time.Time // Last change of the content the page is built from
+}
+
+type RobotsConfig struct {
+	Disallow    []string `toml:"disallow"`     // Paths all crawlers should skip
+	BlockAgents []string `toml:"block_agents"` // User agents denied the whole site
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Xhtml   string       `xml:"xmlns:xhtml,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc        string             `xml:"loc"`
+	LastMod    string             `xml:"lastmod,omitempty"`
+	Alternates []sitemapAlternate `xml:"xhtml:link"`
+}
+
+type sitemapAlternate struct {
+	Rel      string `xml:"rel,attr"`
+	Hreflang string `xml:"hreflang,attr"`
+	Href     string `xml:"href,attr"`
+}
+
+// latestTime returns the most recent of the given times.
+func latestTime(times ...time.Time) time.Time {
+	var latest time.Time
+	for _, t := range times {
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+func writeSitemap(site *SiteConfig, pages []SitemapPage) error {
+	// Both locales report the same paths: merge them
+	byPath := map[string]time.Time{}
+	var paths []string
+	for _, p := range pages {
+		if _, ok := byPath[p.Path]; !ok {
+			paths = append(paths, p.Path)
+		}
+		byPath[p.Path] = latestTime(byPath[p.Path], p.LastMod)
+	}
+	sort.Strings(paths)
+
+	set := sitemapURLSet{
+		Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9",
+		Xhtml: "http://www.w3.org/1999/xhtml",
+	}
+	for _, path := range paths {
+		itURL := site.BaseURL + path
+		enURL := site.BaseURL + computeAlternateUrl("it", path)
+		alternates := []sitemapAlternate{
+			{Rel: "alternate", Hreflang: "it", Href: itURL},
+			{Rel: "alternate", Hreflang: "en", Href: enURL},
+			{Rel: "alternate", Hreflang: "x-default", Href: itURL},
+		}
+
+		var lastmod string
+		if t := byPath[path]; !t.IsZero() {
+			lastmod = t.UTC().Format("2006-01-02")
+		}
+		for _, loc := range []string{itURL, enURL} {
+			set.URLs = append(set.URLs, sitemapURL{Loc: loc, LastMod: lastmod, Alternates: alternates})
+		}
+	}
+
+	out, err := xml.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic("dist/sitemap.xml", append([]byte(xml.Header), append(out, '\n')...))
+}
+
+func writeRobots(site *SiteConfig) error {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	if len(site.Robots.Disallow) == 0 {
+		b.WriteString("Disallow:\n")
+	}
+	for _, path := range site.Robots.Disallow {
+		b.WriteString("Disallow: " + path + "\n")
+	}
+	for _, agent := range site.Robots.BlockAgents {
+		b.WriteString("\nUser-agent: " + agent + "\nDisallow: /\n")
+	}
+	b.WriteString("\nSitemap: " + site.BaseURL + "/sitemap.xml\n")
+
+	return writeFileAtomic("dist/robots.txt", []byte(b.String()))
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// Minimal read-only SQLite reader
+//
+// Just enough of the file format (https://www.sqlite.org/fileformat.html) to
+// scan rowid tables, which is all MBTiles needs: no SQL, no indexes, no
+// WITHOUT ROWID tables, no WAL. It avoids a cgo or multi-megabyte driver
+// dependency for reading one table.
+
+type sqliteDB struct {
+	f        *os.File
+	pageSize int
+	usable   int // Page size minus the reserved bytes at the end of each page
+}
+
+// sqliteRow is a table row whose payload is read lazily: the inline part is
+// usually enough for the leading integer columns, large blobs live in
+// overflow pages.
+type sqliteRow struct {
+	db       *sqliteDB
+	RowID    int64
+	size     int
+	local    []byte
+	overflow uint32
+}
+
+type sqliteTable struct {
+	Type     string // table, view, index
+	Name     string
+	RootPage uint32
+	Columns  []string // From the CREATE statement, in storage order
+}
+
+func openSQLite(path string) (*sqliteDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	hdr := make([]byte, 100)
+	if _, err := f.ReadAt(hdr, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if string(hdr[:16]) != "SQLite format 3\x00" {
+		f.Close()
+		return nil, errors.New("not a SQLite database")
+	}
+	pageSize := int(binary.BigEndian.Uint16(hdr[16:]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if hdr[18] == 2 || hdr[19] == 2 {
+		f.Close()
+		return nil, errors.New("WAL mode databases are not supported, checkpoint the file first")
+	}
+	return &sqliteDB{f: f, pageSize: pageSize, usable: pageSize - int(hdr[20])}, nil
+}
+
+func (db *sqliteDB) Close() error {
+	return db.f.Close()
+}
+
+func (db *sqliteDB) page(n uint32) ([]byte, error) {
+	if n == 0 {
+		return nil, errors.New("invalid page 0")
+	}
+	b := make([]byte, db.pageSize)
+	if _, err := db.f.ReadAt(b, int64(n-1)*int64(db.pageSize)); err != nil {
+		return nil, fmt.Errorf("page %d: %w", n, err)
+	}
+	return b, nil
+}
+
+// scanTable calls fn for every row of the table b-tree rooted at root, in
+// rowid order.
+func (db *sqliteDB) scanTable(root uint32, fn func(row *sqliteRow) error) error {
+	p, err := db.page(root)
+	if err != nil {
+		return err
+	}
+	off := 0
+	if root == 1 {
+		off = 100 // Database header
+	}
+
+	kind := p[off]
+	ncells := int(binary.BigEndian.Uint16(p[off+3:]))
+	switch kind {
+	case 0x05: // Interior table page
+		for i := 0; i < ncells; i++ {
+			cell := int(binary.BigEndian.Uint16(p[off+12+2*i:]))
+			if err := db.scanTable(binary.BigEndian.Uint32(p[cell:]), fn); err != nil {
+				return err
+			}
+		}
+		return db.scanTable(binary.BigEndian.Uint32(p[off+8:]), fn)
+
+	case 0x0d: // Leaf table page
+		for i := 0; i < ncells; i++ {
+			cell := int(binary.BigEndian.Uint16(p[off+8+2*i:]))
+			size, n := sqliteVarint(p[cell:])
+			cell += n
+			rowid, n := sqliteVarint(p[cell:])
+			cell += n
+
+			row := &sqliteRow{db: db, RowID: rowid, size: int(size)}
+			local := db.localPayload(int(size))
+			if cell+local > len(p) {
+				return fmt.Errorf("page %d: corrupt cell", root)
+			}
+			row.local = p[cell : cell+local]
+			if local < int(size) {
+				row.overflow = binary.BigEndian.Uint32(p[cell+local:])
+			}
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("page %d: unsupported b-tree page type 0x%02x", root, kind)
+}
+
+// localPayload is how many payload bytes a table leaf cell stores inline.
+func (db *sqliteDB) localPayload(size int) int {
+	u := db.usable
+	x := u - 35
+	if size <= x {
+		return size
+	}
+	m := ((u-12)*32)/255 - 23
+	k := m + (size-m)%(u-4)
+	if k <= x {
+		return k
+	}
+	return m
+}
+
+// Values decodes the first n columns (all of them when n < 0), reading
+// overflow pages only when the inline payload is not enough.
+func (r *sqliteRow) Values(n int) ([]any, error) {
+	if vals, ok := sqliteRecord(r.local, n); ok {
+		return vals, nil
+	}
+	payload := append([]byte(nil), r.local...)
+	for next := r.overflow; next != 0 && len(payload) < r.size; {
+		p, err := r.db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		next = binary.BigEndian.Uint32(p)
+		chunk := p[4:r.db.usable]
+		if rest := r.size - len(payload); len(chunk) > rest {
+			chunk = chunk[:rest]
+		}
+		payload = append(payload, chunk...)
+	}
+	vals, ok := sqliteRecord(payload, n)
+	if !ok {
+		return nil, fmt.Errorf("row %d: corrupt record", r.RowID)
+	}
+	return vals, nil
+}
+
+// sqliteRecord decodes a record: int64, float64, string, []byte or nil per
+// column. It reports false when p is too short for the requested columns.
+func sqliteRecord(p []byte, n int) ([]any, bool) {
+	hdrLen, k := sqliteVarint(p)
+	if k == 0 || int(hdrLen) > len(p) {
+		return nil, false
+	}
+	var types []int64
+	for off := k; off < int(hdrLen); {
+		t, m := sqliteVarint(p[off:int(hdrLen)])
+		if m == 0 {
+			return nil, false
+		}
+		types = append(types, t)
+		off += m
+	}
+
+	var vals []any
+	off := int(hdrLen)
+	for i, t := range types {
+		if n >= 0 && i == n {
+			break
+		}
+		size := sqliteSerialSize(t)
+		if off+size > len(p) {
+			return nil, false
+		}
+		b := p[off : off+size]
+		switch {
+		case t == 0:
+			vals = append(vals, nil)
+		case t >= 1 && t <= 6:
+			v := int64(int8(b[0])) // Sign-extend from the first byte
+			for _, c := range b[1:] {
+				v = v<<8 | int64(c)
+			}
+			vals = append(vals, v)
+		case t == 7:
+			vals = append(vals, math.Float64frombits(binary.BigEndian.Uint64(b)))
+		case t == 8:
+			vals = append(vals, int64(0))
+		case t == 9:
+			vals = append(vals, int64(1))
+		case t >= 12 && t%2 == 0:
+			vals = append(vals, b)
+		case t >= 13:
+			vals = append(vals, string(b))
+		default:
+			return nil, false
+		}
+		off += size
+	}
+	return vals, true
+}
+
+func sqliteSerialSize(t int64) int {
+	switch {
+	case t <= 4:
+		return int(t)
+	case t == 5:
+		return 6
+	case t == 6 || t == 7:
+		return 8
+	case t >= 12:
+		return int(t-12) / 2
+	}
+	return 0
+}
+
+// sqliteVarint decodes a big-endian varint of up to 9 bytes. The returned
+// length is 0 when b is truncated.
+func sqliteVarint(b []byte) (int64, int) {
+	var v uint64
+	for i := 0; i < 8; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return int64(v), i + 1
+		}
+	}
+	if len(b) < 9 {
+		return 0, 0
+	}
+	return int64(v<<8 | uint64(b[8])), 9
+}
+
+// schema reads sqlite_master.
+func (db *sqliteDB) schema() (map[string]sqliteTable, error) {
+	tables := make(map[string]sqliteTable)
+	err := db.scanTable(1, func(row *sqliteRow) error {
+		vals, err := row.Values(5)
+		if err != nil {
+			return err
+		}
+		if len(vals) < 5 {
+			return nil
+		}
+		t := sqliteTable{}
+		t.Type, _ = vals[0].(string)
+		t.Name, _ = vals[1].(string)
+		if root, ok := vals[3].(int64); ok {
+			t.RootPage = uint32(root)
+		}
+		if sql, ok := vals[4].(string); ok && t.Type == "table" {
+			t.Columns = sqliteColumns(sql)
+		}
+		tables[strings.ToLower(t.Name)] = t
+		return nil
+	})
+	return tables, err
+}
+
+// sqliteColumns extracts the column names of a CREATE TABLE statement. Good
+// enough for the plain schemas MBTiles writers use.
+func sqliteColumns(sql string) []string {
+	open, end := strings.Index(sql, "("), strings.LastIndex(sql, ")")
+	if open < 0 || end < open {
+		return nil
+	}
+	var cols []string
+	for _, def := range strings.Split(sql[open+1:end], ",") {
+		fields := strings.Fields(def)
+		if len(fields) == 0 {
+			continue
+		}
+		name := strings.Trim(fields[0], "\"`[]")
+		switch strings.ToUpper(name) {
+		case "PRIMARY", "UNIQUE", "CHECK", "FOREIGN", "CONSTRAINT":
+			return cols // Table constraints follow the columns
+		}
+		cols = append(cols, strings.ToLower(name))
+	}
+	return cols
+}
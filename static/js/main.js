@@ -20,4 +20,42 @@ document.addEventListener("DOMContentLoaded", () => {
        mobileMenu.classList.toggle("hidden");
     });
   }
-});
\ No newline at end of file
+
+  // Save an itinerary for offline use: the service worker caches its pages,
+  // GPX, photos and map tiles
+  const saveBtn = document.getElementById("save-offline");
+  if (saveBtn && "serviceWorker" in navigator && "caches" in window) {
+    const slug = saveBtn.dataset.slug;
+    const label = saveBtn.querySelector(".save-offline-label");
+    const markSaved = () => {
+      label.textContent = saveBtn.dataset.saved;
+      saveBtn.disabled = true;
+    };
+
+    caches.keys().then(names => {
+      if (names.some(name => name.startsWith("bruggi-trail:" + slug + ":"))) markSaved();
+    });
+    saveBtn.classList.replace("hidden", "flex");
+
+    saveBtn.addEventListener("click", () => {
+      saveBtn.disabled = true;
+      navigator.serviceWorker.ready.then(reg => {
+        reg.active.postMessage({ type: "save-trail", slug: slug });
+      });
+    });
+    navigator.serviceWorker.addEventListener("message", event => {
+      const msg = event.data || {};
+      if (msg.type !== "trail-saved" || msg.slug !== slug) return;
+      if (msg.ok) {
+        markSaved();
+      } else {
+        label.textContent = saveBtn.dataset.failed;
+        saveBtn.disabled = false;
+      }
+    });
+  }
+});
+
+if ("serviceWorker" in navigator) {
+  navigator.serviceWorker.register("/sw.js");
+}
@@ -0,0 +1,93 @@
+// Service worker, generated on every build by pwa.go. Do not edit dist/sw.js.
+//
+// SHELL is cached on install. Each entry of TRAILS is cached when the hiker
+// saves the itinerary from its page, and refreshed when a new build changes it.
+const SHELL = {{ shell|safe }};
+const TRAILS = {{ trails|safe }};
+
+const SHELL_CACHE = "bruggi-shell:" + SHELL.version;
+const TRAIL_PREFIX = "bruggi-trail:";
+
+// Trail caches are named bruggi-trail:<slug>:<version>
+function trailCache(slug) {
+  return TRAIL_PREFIX + slug + ":" + TRAILS[slug].version;
+}
+
+async function saveTrail(slug) {
+  const name = trailCache(slug);
+  const cache = await caches.open(name);
+  try {
+    await cache.addAll(TRAILS[slug].urls);
+  } catch (err) {
+    await caches.delete(name);
+    throw err;
+  }
+}
+
+self.addEventListener("install", (event) => {
+  event.waitUntil(
+    caches.open(SHELL_CACHE)
+      .then((cache) => cache.addAll(SHELL.urls))
+      .then(() => self.skipWaiting())
+  );
+});
+
+self.addEventListener("activate", (event) => {
+  event.waitUntil((async () => {
+    const names = await caches.keys();
+    const keep = new Set([SHELL_CACHE]);
+
+    // Saved trails follow the build; removed trails are dropped. If the
+    // refresh fails (no coverage) the old copy is kept.
+    for (const name of names) {
+      if (!name.startsWith(TRAIL_PREFIX)) continue;
+      const slug = name.split(":")[1];
+      if (!TRAILS[slug]) continue;
+      const current = trailCache(slug);
+      keep.add(current);
+      if (name === current || names.includes(current)) continue;
+      try {
+        await saveTrail(slug);
+      } catch (err) {
+        keep.add(name);
+      }
+    }
+
+    await Promise.all(names
+      .filter((name) => name.startsWith("bruggi-") && !keep.has(name))
+      .map((name) => caches.delete(name)));
+    await self.clients.claim();
+  })());
+});
+
+self.addEventListener("message", (event) => {
+  const msg = event.data || {};
+  if (msg.type !== "save-trail") return;
+
+  const reply = (ok) => event.source && event.source.postMessage({ type: "trail-saved", slug: msg.slug, ok: ok });
+  if (!TRAILS[msg.slug]) {
+    reply(false);
+    return;
+  }
+  event.waitUntil(saveTrail(msg.slug).then(() => reply(true), () => reply(false)));
+});
+
+self.addEventListener("fetch", (event) => {
+  const req = event.request;
+  const url = new URL(req.url);
+  if (req.method !== "GET" || url.origin !== self.location.origin) return;
+
+  if (req.mode === "navigate") {
+    // Pages: fresh when online, the cached copy without coverage, else the home page
+    const home = url.pathname.startsWith("/en/") ? "/en/" : "/";
+    event.respondWith(
+      fetch(req).catch(() =>
+        caches.match(req, { ignoreSearch: true }).then((res) => res || caches.match(home))
+      )
+    );
+    return;
+  }
+
+  // Assets: only precached files are in the caches, their names change with the content
+  event.respondWith(caches.match(req).then((res) => res || fetch(req)));
+});
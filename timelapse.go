@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// Webcam Time-lapses
+//
+// Animated GIFs (the only animated format with a pure-Go encoder) assembled
+// from the archived snapshots of each camera: today, yesterday and the last 7
+// days, written to dist/static/webcam/<id>/timelapse/ with a clips.json
+// describing them. The webcam page plays a clip instead of downloading every
+// frame.
+
+func timelapseDir(cam WebcamCamera) string {
+	return filepath.Join(cam.DistDir(), "timelapse")
+}
+
+type TimelapseClip struct {
+	ID     string `json:"id"` // today, yesterday, week
+	Url    string `json:"url"`
+	Frames int    `json:"frames"`
+	Hash   string `json:"hash"` // Frames and settings the clip was made from
+}
+
+type timelapseWindow struct {
+	ID       string
+	From, To time.Time
+}
+
+// timelapseWindows are the clips and the snapshot times they cover. Days
+// are those of now's location, the site timezone.
+func timelapseWindows(now time.Time) []timelapseWindow {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return []timelapseWindow{
+		{"today", midnight, now},
+		{"yesterday", midnight.AddDate(0, 0, -1), midnight},
+		{"week", now.AddDate(0, 0, -7), now},
+	}
+}
+
+// writeTimelapses makes the clips of cam from its archive. Unless force is
+// set, clips whose frames did not change since the last run are kept as they
+// are (a webcam update then only re-encodes today and the week).
+func writeTimelapses(site *SiteConfig, cam WebcamCamera, now time.Time, force bool) error {
+	snaps, err := webcamSnapshots(cam.Dir(), site.Location)
+	if err != nil {
+		return fmt.Errorf("listing webcam snapshots: %w", err)
+	}
+	dir := timelapseDir(cam)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating time-lapse dir: %w", err)
+	}
+
+	previous := make(map[string]TimelapseClip)
+	for _, c := range loadTimelapses(cam) {
+		previous[c.ID] = c
+	}
+
+	var errs []error
+	clips := []TimelapseClip{}
+	for _, w := range timelapseWindows(now.In(site.Location)) {
+		path := filepath.Join(dir, w.ID+".gif")
+
+		var frames []webcamSnapshot
+		for _, s := range snaps {
+			if !s.Time.Before(w.From) && s.Time.Before(w.To) {
+				frames = append(frames, s)
+			}
+		}
+		frames = sampleSnapshots(frames, site.Webcam.TimelapseFrames)
+		if len(frames) < 2 {
+			os.Remove(path) // Nothing to animate, drop the previous clip
+			continue
+		}
+
+		clip := TimelapseClip{ID: w.ID, Url: cam.Url() + "/timelapse/" + w.ID + ".gif", Frames: len(frames)}
+		clip.Hash = timelapseHash(frames, site.Webcam.TimelapseWidth)
+		if _, err := os.Stat(path); err == nil && !force && previous[w.ID].Hash == clip.Hash {
+			clips = append(clips, clip)
+			continue
+		}
+
+		if err := writeTimelapseGIF(cam, path, frames, site.Webcam.TimelapseWidth); err != nil {
+			errs = append(errs, fmt.Errorf("writing time-lapse %s: %w", w.ID, err))
+			continue
+		}
+		clips = append(clips, clip)
+	}
+
+	b, err := json.MarshalIndent(clips, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(filepath.Join(dir, "clips.json"), b)
+	}
+	if err != nil {
+		errs = append(errs, fmt.Errorf("writing time-lapse list: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// loadTimelapses reads the clips written by writeTimelapses, for the pages.
+func loadTimelapses(cam WebcamCamera) []TimelapseClip {
+	var clips []TimelapseClip
+	b, err := os.ReadFile(filepath.Join(timelapseDir(cam), "clips.json"))
+	if err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(b, &clips); err != nil {
+		log.Printf("Warning: invalid time-lapse list: %v", err)
+		return nil
+	}
+	return clips
+}
+
+// sampleSnapshots keeps at most n snapshots, evenly spread, always including
+// the last one.
+func sampleSnapshots(snaps []webcamSnapshot, n int) []webcamSnapshot {
+	if n <= 0 || len(snaps) <= n {
+		return snaps
+	}
+	out := make([]webcamSnapshot, n)
+	for i := range out {
+		out[i] = snaps[i*(len(snaps)-1)/(n-1)]
+	}
+	return out
+}
+
+func timelapseHash(frames []webcamSnapshot, width int) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n", width)
+	for _, f := range frames {
+		io.WriteString(h, f.Name+"\n")
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+func writeTimelapseGIF(cam WebcamCamera, path string, frames []webcamSnapshot, width int) error {
+	// Decode and resize first: the palette is shared by all frames. Frames
+	// are the web versions (with the capture time), cropped to the first
+	// one's shape, GIF frames cannot outgrow it.
+	var imgs []*image.NRGBA
+	height := 0
+	for _, f := range frames {
+		src, err := imaging.Open(filepath.Join(cam.Dir(), webcamWebDir, f.Name))
+		if err != nil {
+			log.Printf("Warning: skipping time-lapse frame %s: %v", f.Name, err)
+			continue
+		}
+		if height == 0 {
+			height = max(1, width*src.Bounds().Dy()/src.Bounds().Dx())
+		}
+		imgs = append(imgs, imaging.Fill(src, width, height, imaging.Center, imaging.Linear))
+	}
+	if len(imgs) == 0 {
+		return fmt.Errorf("no readable frames")
+	}
+
+	// The last palette entry is transparent: pixels that did not change since
+	// the previous frame (most of a fixed camera view) are left out
+	pal := medianCutPalette(timelapseSamples(imgs), 255)
+	lut := newPaletteLUT(pal)
+	transparent := uint8(len(pal))
+	pal = append(pal, color.Transparent)
+
+	anim := &gif.GIF{LoopCount: 0}
+	var canvas *image.Paletted
+	for i, img := range imgs {
+		frame := lut.Paletted(img)
+		frame.Palette = pal
+		if canvas == nil {
+			canvas = image.NewPaletted(frame.Rect, pal)
+			copy(canvas.Pix, frame.Pix)
+		} else {
+			frame = timelapseDelta(canvas, frame, transparent)
+		}
+
+		delay := 12 // 1/100 s
+		if i == len(imgs)-1 {
+			delay = 150 // Pause on the latest frame before looping
+		}
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, delay)
+		anim.Disposal = append(anim.Disposal, gif.DisposalNone)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// timelapseSamples takes about 200k pixels across all frames for the palette.
+func timelapseSamples(imgs []*image.NRGBA) [][3]uint8 {
+	total := 0
+	for _, img := range imgs {
+		total += len(img.Pix) / 4
+	}
+	step := max(1, total/200000)
+	var samples [][3]uint8
+	for _, img := range imgs {
+		for i := 0; i+3 < len(img.Pix); i += 4 * step {
+			samples = append(samples, [3]uint8{img.Pix[i], img.Pix[i+1], img.Pix[i+2]})
+		}
+	}
+	return samples
+}
+
+// medianCutPalette splits the color space, box by box, at the median of the
+// widest channel until there are n boxes; each box becomes its mean color.
+func medianCutPalette(samples [][3]uint8, n int) color.Palette {
+	boxes := [][][3]uint8{samples}
+	for len(boxes) < n {
+		best, bestChannel, bestRange := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			for c := 0; c < 3; c++ {
+				lo, hi := uint8(255), uint8(0)
+				for _, p := range box {
+					lo, hi = min(lo, p[c]), max(hi, p[c])
+				}
+				if r := int(hi) - int(lo); r > bestRange {
+					best, bestChannel, bestRange = i, c, r
+				}
+			}
+		}
+		if best < 0 {
+			break // Every box is a single color
+		}
+		box := boxes[best]
+		sort.Slice(box, func(i, j int) bool { return box[i][bestChannel] < box[j][bestChannel] })
+		mid := len(box) / 2
+		boxes[best] = box[:mid]
+		boxes = append(boxes, box[mid:])
+	}
+
+	pal := make(color.Palette, 0, len(boxes))
+	for _, box := range boxes {
+		if len(box) == 0 {
+			continue
+		}
+		var r, g, b int
+		for _, p := range box {
+			r, g, b = r+int(p[0]), g+int(p[1]), b+int(p[2])
+		}
+		n := len(box)
+		pal = append(pal, color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), 0xff})
+	}
+	return pal
+}
+
+// paletteLUT maps 5-bit-per-channel colors to palette indexes, so frames are
+// converted without searching the palette for every pixel.
+type paletteLUT struct {
+	pal   color.Palette
+	index [32 * 32 * 32]uint8
+}
+
+func newPaletteLUT(pal color.Palette) *paletteLUT {
+	l := &paletteLUT{pal: pal}
+	for i := range l.index {
+		r, g, b := (i>>10)&31, (i>>5)&31, i&31
+		l.index[i] = uint8(pal.Index(color.RGBA{uint8(r<<3 | 4), uint8(g<<3 | 4), uint8(b<<3 | 4), 0xff}))
+	}
+	return l
+}
+
+func (l *paletteLUT) Paletted(img *image.NRGBA) *image.Paletted {
+	b := img.Bounds()
+	out := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), l.pal)
+	for y := 0; y < b.Dy(); y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < b.Dx(); x++ {
+			r, g, bl := row[x*4]>>3, row[x*4+1]>>3, row[x*4+2]>>3
+			out.Pix[y*out.Stride+x] = l.index[int(r)<<10|int(g)<<5|int(bl)]
+		}
+	}
+	return out
+}
+
+// timelapseDelta returns the part of cur that visibly differs from canvas
+// (what the viewer currently sees), with the other pixels set to the
+// transparent index, and updates canvas. Comparing against the canvas keeps
+// the error of skipped pixels within timelapseTolerance.
+func timelapseDelta(canvas, cur *image.Paletted, transparent uint8) *image.Paletted {
+	pal := cur.Palette
+	b := cur.Bounds()
+	out := image.NewPaletted(b, pal)
+	x0, y0, x1, y1 := b.Max.X, b.Max.Y, b.Min.X, b.Min.Y
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			i := cur.PixOffset(x, y)
+			if paletteDistance(pal[canvas.Pix[i]], pal[cur.Pix[i]]) <= timelapseTolerance {
+				out.Pix[i] = transparent
+				continue
+			}
+			out.Pix[i] = cur.Pix[i]
+			canvas.Pix[i] = cur.Pix[i]
+			x0, y0, x1, y1 = min(x0, x), min(y0, y), max(x1, x+1), max(y1, y+1)
+		}
+	}
+	changed := image.Rect(x0, y0, x1, y1)
+	if changed.Empty() {
+		changed = image.Rect(0, 0, 1, 1) // GIF frames cannot be empty
+	}
+	return out.SubImage(changed).(*image.Paletted)
+}
+
+// timelapseTolerance is the squared RGB distance below which a pixel is
+// considered unchanged: sensor noise and slow light changes.
+const timelapseTolerance = 3 * 10 * 10
+
+func paletteDistance(a, b color.Color) int {
+	ar, ag, ab, _ := a.RGBA()
+	br, bg, bb, _ := b.RGBA()
+	dr, dg, db := int(ar>>8)-int(br>>8), int(ag>>8)-int(bg>>8), int(ab>>8)-int(bb>>8)
+	return dr*dr + dg*dg + db*db
+}
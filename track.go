@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Track Model
+
+// Track is the format-independent representation of a recorded route.
+// Every importer (GPX, FIT, KML/KMZ, GeoJSON) normalizes into this model.
+type Track struct {
+	Name     string
+	Format   string
+	Segments [][]TrackPoint
+}
+
+type TrackPoint struct {
+	Lat    float64
+	Lon    float64
+	Ele    float64
+	HasEle bool
+	Time   time.Time
+}
+
+const (
+	trackFormatGPX     = "gpx"
+	trackFormatFIT     = "fit"
+	trackFormatKML     = "kml"
+	trackFormatKMZ     = "kmz"
+	trackFormatGeoJSON = "geojson"
+)
+
+var trackExtensions = map[string]string{
+	".gpx":     trackFormatGPX,
+	".fit":     trackFormatFIT,
+	".kml":     trackFormatKML,
+	".kmz":     trackFormatKMZ,
+	".geojson": trackFormatGeoJSON,
+	".json":    trackFormatGeoJSON,
+}
+
+// Points returns all the track points, segments concatenated.
+func (t *Track) Points() []TrackPoint {
+	var pts []TrackPoint
+	for _, seg := range t.Segments {
+		pts = append(pts, seg...)
+	}
+	return pts
+}
+
+// loadTrack reads a track file in any supported format.
+func loadTrack(path string) (*Track, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	format, err := detectTrackFormat(path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var t *Track
+	switch format {
+	case trackFormatGPX:
+		t, err = parseGpx(data)
+	case trackFormatFIT:
+		t, err = parseFit(data)
+	case trackFormatKML:
+		t, err = parseKml(data)
+	case trackFormatKMZ:
+		t, err = parseKmz(data)
+	case trackFormatGeoJSON:
+		t, err = parseGeoJSON(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", format, err)
+	}
+
+	// Drop empty segments so consumers can rely on len(seg) > 0
+	var segs [][]TrackPoint
+	for _, seg := range t.Segments {
+		if len(seg) > 0 {
+			segs = append(segs, seg)
+		}
+	}
+	if len(segs) == 0 {
+		return nil, fmt.Errorf("no track points found in %s", path)
+	}
+	t.Segments = segs
+	t.Format = format
+	if t.Name == "" {
+		t.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return t, nil
+}
+
+// detectTrackFormat sniffs the file content first (extensions from exports are
+// not always reliable) and falls back to the file extension.
+func detectTrackFormat(path string, data []byte) (string, error) {
+	if format := sniffTrackFormat(data); format != "" {
+		return format, nil
+	}
+	if format, ok := trackExtensions[strings.ToLower(filepath.Ext(path))]; ok {
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown track format: %s", path)
+}
+
+func sniffTrackFormat(data []byte) string {
+	// FIT: ".FIT" signature at bytes 8-11 of the file header
+	if len(data) >= 12 && string(data[8:12]) == ".FIT" {
+		return trackFormatFIT
+	}
+	// KMZ: a zip archive
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return trackFormatKMZ
+	}
+
+	head := data
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	head = bytes.TrimSpace(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")))
+
+	if bytes.HasPrefix(head, []byte("<")) {
+		switch {
+		case bytes.Contains(head, []byte("<gpx")):
+			return trackFormatGPX
+		case bytes.Contains(head, []byte("<kml")):
+			return trackFormatKML
+		}
+	}
+	if bytes.HasPrefix(head, []byte("{")) {
+		return trackFormatGeoJSON
+	}
+	return ""
+}
+
+// trackStats computes the total elevation gain (m) and distance (km).
+// Gaps between segments are not counted.
+func trackStats(t *Track) (elevationGain int, distanceKm float64) {
+	var gain float64
+	var dist float64
+
+	for _, seg := range t.Segments {
+		for i := 1; i < len(seg); i++ {
+			prev, pt := seg[i-1], seg[i]
+
+			// Elevation Gain
+			if diff := pt.Ele - prev.Ele; diff > 0 && pt.HasEle && prev.HasEle {
+				gain += diff
+			}
+
+			// Distance
+			dist += haversine(prev.Lat, prev.Lon, pt.Lat, pt.Lon)
+		}
+	}
+
+	return int(math.Round(gain)), math.Round((dist/1000)*100) / 100
+}
+
+// trackBounds returns the extent of the track.
+func trackBounds(t *Track) BoundingBox {
+	b := BoundingBox{MinLat: 90, MinLon: 180, MaxLat: -90, MaxLon: -180}
+	for _, seg := range t.Segments {
+		for _, pt := range seg {
+			b.MinLat = math.Min(b.MinLat, pt.Lat)
+			b.MinLon = math.Min(b.MinLon, pt.Lon)
+			b.MaxLat = math.Max(b.MaxLat, pt.Lat)
+			b.MaxLon = math.Max(b.MaxLon, pt.Lon)
+		}
+	}
+	return b
+}
+
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	const R = 6371000 // Earth radius in meters
+	phi1 := lat1 * math.Pi / 180
+	phi2 := lat2 * math.Pi / 180
+	deltaPhi := (lat2 - lat1) * math.Pi / 180
+	deltaLambda := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(deltaPhi/2)*math.Sin(deltaPhi/2) +
+		math.Cos(phi1)*math.Cos(phi2)*
+			math.Sin(deltaLambda/2)*math.Sin(deltaLambda/2)
+	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+
+	return R * c
+}
+
+// publishedGpxUrl returns the web path of the GPX download for a track source.
+// GPX sources keep their URL, other formats get a sibling ".gpx" file.
+func publishedGpxUrl(sourceUrl string) string {
+	ext := filepath.Ext(sourceUrl)
+	if strings.EqualFold(ext, ".gpx") {
+		return sourceUrl
+	}
+	return strings.TrimSuffix(sourceUrl, ext) + ".gpx"
+}
+
+// isTrackSource reports whether a path relative to static/ is a recorded
+// track. Those keep timestamps, heart rate and device data, so they are left
+// out of dist and only their published GPX is served.
+func isTrackSource(rel string) bool {
+	dir, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+	_, ok := trackExtensions[strings.ToLower(filepath.Ext(rel))]
+	return dir == "gpx" && ok
+}
+
+// publishTracks writes the public GPX download into dist for every
+// itinerary with a track. The source file in static/ is left untouched: the
+// published copy is simplified and stripped of timestamps and device
+// extensions (heart rate, cadence...) so it does not leak personal data.
+// A failed track does not stop the others.
+func publishTracks(site *SiteConfig, itineraries []ItineraryFile) error {
+	var errs []error
+	for _, it := range itineraries {
+		if it.Track == nil {
+			continue
+		}
+		outPath := filepath.Join("dist", strings.TrimPrefix(it.PublishedGpx, "/"))
+		if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+			errs = append(errs, fmt.Errorf("creating GPX dir for %s: %w", it.Slug, err))
+			continue
+		}
+
+		meta := gpxMetadata{
+			Name: it.It.Title,
+			Link: site.BaseURL + "/itineraries/" + it.Slug + ".html",
+		}
+		if meta.Name == "" {
+			meta.Name = it.Track.Name
+		}
+
+		simplified := simplifyTrack(it.Track, site.Gpx.SimplifyTolerance)
+		if err := writeGpx(outPath, simplified, meta); err != nil {
+			errs = append(errs, fmt.Errorf("publishing GPX for %s: %w", it.Slug, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// simplifyTrack reduces the number of points with Douglas-Peucker, keeping
+// every point that deviates more than toleranceM meters from the simplified
+// line. A tolerance <= 0 returns the track unchanged.
+func simplifyTrack(t *Track, toleranceM float64) *Track {
+	if toleranceM <= 0 {
+		return t
+	}
+	out := &Track{Name: t.Name, Format: t.Format}
+	for _, seg := range t.Segments {
+		out.Segments = append(out.Segments, simplifySegment(seg, toleranceM))
+	}
+	return out
+}
+
+func simplifySegment(seg []TrackPoint, toleranceM float64) []TrackPoint {
+	if len(seg) < 3 {
+		return seg
+	}
+
+	// Project to a local plane in meters (equirectangular is accurate
+	// enough at the scale of a single hike)
+	const R = 6371000
+	lat0 := seg[0].Lat * math.Pi / 180
+	xs := make([]float64, len(seg))
+	ys := make([]float64, len(seg))
+	for i, pt := range seg {
+		xs[i] = pt.Lon * math.Pi / 180 * math.Cos(lat0) * R
+		ys[i] = pt.Lat * math.Pi / 180 * R
+	}
+
+	keep := make([]bool, len(seg))
+	keep[0], keep[len(seg)-1] = true, true
+
+	// Iterative to avoid deep recursion on long recordings
+	stack := [][2]int{{0, len(seg) - 1}}
+	for len(stack) > 0 {
+		r := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		first, last := r[0], r[1]
+
+		maxDist, index := 0.0, -1
+		for i := first + 1; i < last; i++ {
+			d := pointSegmentDistance(xs[i], ys[i], xs[first], ys[first], xs[last], ys[last])
+			if d > maxDist {
+				maxDist, index = d, i
+			}
+		}
+		if index != -1 && maxDist > toleranceM {
+			keep[index] = true
+			stack = append(stack, [2]int{first, index}, [2]int{index, last})
+		}
+	}
+
+	var out []TrackPoint
+	for i, pt := range seg {
+		if keep[i] {
+			out = append(out, pt)
+		}
+	}
+	return out
+}
+
+// pointSegmentDistance returns the distance from (px, py) to the segment
+// (ax, ay)-(bx, by).
+func pointSegmentDistance(px, py, ax, ay, bx, by float64) float64 {
+	dx, dy := bx-ax, by-ay
+	if dx == 0 && dy == 0 {
+		return math.Hypot(px-ax, py-ay)
+	}
+	u := ((px-ax)*dx + (py-ay)*dy) / (dx*dx + dy*dy)
+	u = math.Max(0, math.Min(1, u))
+	return math.Hypot(px-(ax+u*dx), py-(ay+u*dy))
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Minimal decoder for Garmin FIT activity files. Only "record" messages
+// (global message 20) are read, which is where position, altitude and
+// timestamp samples live. Everything else is skipped using the definitions.
+
+const (
+	fitMesgRecord = 20
+
+	fitFieldPositionLat      = 0
+	fitFieldPositionLong     = 1
+	fitFieldAltitude         = 2
+	fitFieldEnhancedAltitude = 78
+	fitFieldTimestamp        = 253
+)
+
+// FIT timestamps count seconds from 1989-12-31T00:00:00Z
+var fitEpoch = time.Date(1989, 12, 31, 0, 0, 0, 0, time.UTC)
+
+type fitFieldDef struct {
+	Num  byte
+	Size int
+}
+
+type fitDefinition struct {
+	Global    uint16
+	Order     binary.ByteOrder
+	Fields    []fitFieldDef
+	DevFields int // total size in bytes of developer fields
+}
+
+func parseFit(data []byte) (*Track, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("file too short")
+	}
+	headerSize := int(data[0])
+	if headerSize < 12 || len(data) < headerSize {
+		return nil, fmt.Errorf("invalid header size %d", headerSize)
+	}
+	dataSize := int(binary.LittleEndian.Uint32(data[4:8]))
+	end := headerSize + dataSize
+	if end > len(data) {
+		return nil, fmt.Errorf("truncated file")
+	}
+
+	defs := make(map[byte]*fitDefinition)
+	var seg []TrackPoint
+	var lastTimestamp uint32
+
+	pos := headerSize
+	for pos < end {
+		header := data[pos]
+		pos++
+
+		var local byte
+		compressedTime := false
+		var timeOffset uint32
+
+		if header&0x80 != 0 {
+			// Compressed timestamp header
+			local = (header >> 5) & 0x03
+			compressedTime = true
+			timeOffset = uint32(header & 0x1F)
+		} else {
+			local = header & 0x0F
+			if header&0x40 != 0 {
+				def, n, err := parseFitDefinition(data[pos:end], header&0x20 != 0)
+				if err != nil {
+					return nil, err
+				}
+				defs[local] = def
+				pos += n
+				continue
+			}
+		}
+
+		def, ok := defs[local]
+		if !ok {
+			return nil, fmt.Errorf("data message for undefined local type %d", local)
+		}
+
+		size := def.DevFields
+		for _, f := range def.Fields {
+			size += f.Size
+		}
+		if pos+size > end {
+			return nil, fmt.Errorf("truncated data message")
+		}
+		msg := data[pos : pos+size]
+		pos += size
+
+		if compressedTime {
+			lastTimestamp += (timeOffset - lastTimestamp&0x1F) & 0x1F
+		}
+
+		var pt TrackPoint
+		var hasLat, hasLon bool
+		var ts uint32
+		hasTs := false
+
+		off := 0
+		for _, f := range def.Fields {
+			raw := msg[off : off+f.Size]
+			off += f.Size
+
+			switch {
+			case f.Num == fitFieldTimestamp && f.Size == 4:
+				if v := def.Order.Uint32(raw); v != 0xFFFFFFFF {
+					ts, hasTs = v, true
+				}
+			case def.Global != fitMesgRecord:
+				continue
+			case f.Num == fitFieldPositionLat && f.Size == 4:
+				if v := int32(def.Order.Uint32(raw)); v != 0x7FFFFFFF {
+					pt.Lat, hasLat = fitSemicircles(v), true
+				}
+			case f.Num == fitFieldPositionLong && f.Size == 4:
+				if v := int32(def.Order.Uint32(raw)); v != 0x7FFFFFFF {
+					pt.Lon, hasLon = fitSemicircles(v), true
+				}
+			case f.Num == fitFieldAltitude && f.Size == 2:
+				if v := def.Order.Uint16(raw); v != 0xFFFF && !pt.HasEle {
+					pt.Ele, pt.HasEle = float64(v)/5-500, true
+				}
+			case f.Num == fitFieldEnhancedAltitude && f.Size == 4:
+				// Enhanced altitude has more range and wins over altitude
+				if v := def.Order.Uint32(raw); v != 0xFFFFFFFF {
+					pt.Ele, pt.HasEle = float64(v)/5-500, true
+				}
+			}
+		}
+
+		if hasTs {
+			lastTimestamp = ts
+		}
+		if def.Global != fitMesgRecord || !hasLat || !hasLon {
+			continue
+		}
+		if hasTs || compressedTime {
+			pt.Time = fitEpoch.Add(time.Duration(lastTimestamp) * time.Second)
+		}
+		seg = append(seg, pt)
+	}
+
+	return &Track{Segments: [][]TrackPoint{seg}}, nil
+}
+
+// parseFitDefinition decodes a definition message and returns it with the
+// number of bytes consumed.
+func parseFitDefinition(b []byte, hasDevFields bool) (*fitDefinition, int, error) {
+	if len(b) < 5 {
+		return nil, 0, fmt.Errorf("truncated definition message")
+	}
+	def := &fitDefinition{Order: binary.LittleEndian}
+	if b[1] == 1 {
+		def.Order = binary.BigEndian
+	}
+	def.Global = def.Order.Uint16(b[2:4])
+	numFields := int(b[4])
+	n := 5
+
+	if len(b) < n+numFields*3 {
+		return nil, 0, fmt.Errorf("truncated field definitions")
+	}
+	for i := 0; i < numFields; i++ {
+		def.Fields = append(def.Fields, fitFieldDef{Num: b[n], Size: int(b[n+1])})
+		n += 3
+	}
+
+	if hasDevFields {
+		if len(b) < n+1 {
+			return nil, 0, fmt.Errorf("truncated developer field count")
+		}
+		numDev := int(b[n])
+		n++
+		if len(b) < n+numDev*3 {
+			return nil, 0, fmt.Errorf("truncated developer field definitions")
+		}
+		for i := 0; i < numDev; i++ {
+			def.DevFields += int(b[n+1])
+			n += 3
+		}
+	}
+
+	return def, n, nil
+}
+
+func fitSemicircles(v int32) float64 {
+	return float64(v) * (180.0 / 2147483648.0)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type geoJSONObject struct {
+	Type        string          `json:"type"`
+	Features    []geoJSONObject `json:"features"`
+	Geometry    *geoJSONObject  `json:"geometry"`
+	Geometries  []geoJSONObject `json:"geometries"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Properties  map[string]any  `json:"properties"`
+}
+
+// parseGeoJSON collects every LineString and MultiLineString in a GeoJSON
+// document (bare geometry, Feature or FeatureCollection).
+func parseGeoJSON(data []byte) (*Track, error) {
+	var root geoJSONObject
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	t := &Track{}
+	if err := collectGeoJSON(&root, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func collectGeoJSON(obj *geoJSONObject, t *Track) error {
+	switch obj.Type {
+	case "FeatureCollection":
+		for i := range obj.Features {
+			if err := collectGeoJSON(&obj.Features[i], t); err != nil {
+				return err
+			}
+		}
+	case "Feature":
+		if name, ok := obj.Properties["name"].(string); ok && t.Name == "" {
+			t.Name = name
+		}
+		if obj.Geometry != nil {
+			return collectGeoJSON(obj.Geometry, t)
+		}
+	case "GeometryCollection":
+		for i := range obj.Geometries {
+			if err := collectGeoJSON(&obj.Geometries[i], t); err != nil {
+				return err
+			}
+		}
+	case "LineString":
+		var coords [][]float64
+		if err := json.Unmarshal(obj.Coordinates, &coords); err != nil {
+			return fmt.Errorf("LineString coordinates: %w", err)
+		}
+		t.Segments = append(t.Segments, geoJSONPositions(coords))
+	case "MultiLineString":
+		var lines [][][]float64
+		if err := json.Unmarshal(obj.Coordinates, &lines); err != nil {
+			return fmt.Errorf("MultiLineString coordinates: %w", err)
+		}
+		for _, line := range lines {
+			t.Segments = append(t.Segments, geoJSONPositions(line))
+		}
+	}
+	return nil
+}
+
+// geoJSONPositions converts [lon, lat, (ele)] positions to track points.
+func geoJSONPositions(coords [][]float64) []TrackPoint {
+	var pts []TrackPoint
+	for _, c := range coords {
+		if len(c) < 2 {
+			continue
+		}
+		pt := TrackPoint{Lat: c[1], Lon: c[0]}
+		if len(c) > 2 {
+			pt.Ele = c[2]
+			pt.HasEle = true
+		}
+		pts = append(pts, pt)
+	}
+	return pts
+}
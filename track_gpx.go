@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strconv"
+	"time"
+)
+
+// GPX Parsing Structures
+
+type Gpx struct {
+	Metadata struct {
+		Name string `xml:"name"`
+	} `xml:"metadata"`
+	Trk []Trk `xml:"trk"`
+	Rte []Rte `xml:"rte"`
+}
+
+type Trk struct {
+	Name   string   `xml:"name"`
+	TrkSeg []TrkSeg `xml:"trkseg"`
+}
+
+type TrkSeg struct {
+	TrkPt []TrkPt `xml:"trkpt"`
+}
+
+type Rte struct {
+	Name  string  `xml:"name"`
+	RtePt []TrkPt `xml:"rtept"`
+}
+
+type TrkPt struct {
+	Lat  float64  `xml:"lat,attr"`
+	Lon  float64  `xml:"lon,attr"`
+	Ele  *float64 `xml:"ele"`
+	Time string   `xml:"time"`
+}
+
+func (p TrkPt) toTrackPoint() TrackPoint {
+	tp := TrackPoint{Lat: p.Lat, Lon: p.Lon}
+	if p.Ele != nil {
+		tp.Ele = *p.Ele
+		tp.HasEle = true
+	}
+	if p.Time != "" {
+		if t, err := time.Parse(time.RFC3339, p.Time); err == nil {
+			tp.Time = t
+		}
+	}
+	return tp
+}
+
+func parseGpx(data []byte) (*Track, error) {
+	var gpx Gpx
+	if err := xml.NewDecoder(bytes.NewReader(data)).Decode(&gpx); err != nil {
+		return nil, err
+	}
+
+	t := &Track{Name: gpx.Metadata.Name}
+	for _, trk := range gpx.Trk {
+		if t.Name == "" {
+			t.Name = trk.Name
+		}
+		for _, seg := range trk.TrkSeg {
+			var pts []TrackPoint
+			for _, pt := range seg.TrkPt {
+				pts = append(pts, pt.toTrackPoint())
+			}
+			t.Segments = append(t.Segments, pts)
+		}
+	}
+
+	// Planned routes (e.g. exported before recording) have no <trk>
+	if len(t.Segments) == 0 {
+		for _, rte := range gpx.Rte {
+			if t.Name == "" {
+				t.Name = rte.Name
+			}
+			var pts []TrackPoint
+			for _, pt := range rte.RtePt {
+				pts = append(pts, pt.toTrackPoint())
+			}
+			t.Segments = append(t.Segments, pts)
+		}
+	}
+
+	return t, nil
+}
+
+// GPX Writing Structures
+
+type gpxOut struct {
+	XMLName  xml.Name       `xml:"gpx"`
+	Version  string         `xml:"version,attr"`
+	Creator  string         `xml:"creator,attr"`
+	Xmlns    string         `xml:"xmlns,attr"`
+	Metadata gpxOutMetadata `xml:"metadata"`
+	Trk      gpxOutTrack    `xml:"trk"`
+}
+
+type gpxOutMetadata struct {
+	Name string      `xml:"name,omitempty"`
+	Link *gpxOutLink `xml:"link,omitempty"`
+}
+
+type gpxOutLink struct {
+	Href string `xml:"href,attr"`
+	Text string `xml:"text,omitempty"`
+}
+
+type gpxOutTrack struct {
+	Name   string          `xml:"name,omitempty"`
+	TrkSeg []gpxOutSegment `xml:"trkseg"`
+}
+
+type gpxOutSegment struct {
+	TrkPt []gpxOutPoint `xml:"trkpt"`
+}
+
+type gpxOutPoint struct {
+	Lat string `xml:"lat,attr"`
+	Lon string `xml:"lon,attr"`
+	Ele string `xml:"ele,omitempty"`
+}
+
+// gpxMetadata describes the published file in its <metadata> block.
+type gpxMetadata struct {
+	Name string
+	Link string
+}
+
+// writeGpx serializes a track as a GPX 1.1 file. Only positions and
+// elevations are written: timestamps and extensions are intentionally dropped.
+func writeGpx(path string, t *Track, meta gpxMetadata) error {
+	doc := gpxOut{
+		Version:  "1.1",
+		Creator:  "bruggi.it",
+		Xmlns:    "http://www.topografix.com/GPX/1/1",
+		Metadata: gpxOutMetadata{Name: meta.Name},
+		Trk:      gpxOutTrack{Name: meta.Name},
+	}
+	if meta.Link != "" {
+		doc.Metadata.Link = &gpxOutLink{Href: meta.Link, Text: meta.Name}
+	}
+	for _, seg := range t.Segments {
+		var outSeg gpxOutSegment
+		for _, pt := range seg {
+			op := gpxOutPoint{
+				Lat: strconv.FormatFloat(pt.Lat, 'f', 6, 64),
+				Lon: strconv.FormatFloat(pt.Lon, 'f', 6, 64),
+			}
+			if pt.HasEle {
+				op.Ele = strconv.FormatFloat(pt.Ele, 'f', 1, 64)
+			}
+			outSeg.TrkPt = append(outSeg.TrkPt, op)
+		}
+		doc.Trk.TrkSeg = append(doc.Trk.TrkSeg, outSeg)
+	}
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append([]byte(xml.Header), append(out, '\n')...))
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseKml extracts LineString and gx:Track geometries from a KML document.
+// Namespaces are ignored: Google Earth and other exporters disagree on them.
+func parseKml(data []byte) (*Track, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+
+	t := &Track{}
+	var stack []string
+	var text strings.Builder
+	var whens []time.Time
+	var coords []TrackPoint
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch el := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, el.Name.Local)
+			text.Reset()
+			if el.Name.Local == "Track" {
+				whens, coords = nil, nil
+			}
+		case xml.CharData:
+			text.Write(el)
+		case xml.EndElement:
+			parent := ""
+			if len(stack) > 1 {
+				parent = stack[len(stack)-2]
+			}
+			value := strings.TrimSpace(text.String())
+
+			switch el.Name.Local {
+			case "name":
+				if t.Name == "" && (parent == "Document" || parent == "Placemark" || parent == "Folder") {
+					t.Name = value
+				}
+			case "coordinates":
+				if parent == "LineString" {
+					t.Segments = append(t.Segments, parseKmlCoordinates(value))
+				}
+			case "when":
+				if parent == "Track" {
+					when, _ := time.Parse(time.RFC3339, value)
+					whens = append(whens, when)
+				}
+			case "coord":
+				if parent == "Track" {
+					if pt, ok := parseKmlTuple(strings.Fields(value)); ok {
+						coords = append(coords, pt)
+					}
+				}
+			case "Track":
+				for i := range coords {
+					if i < len(whens) {
+						coords[i].Time = whens[i]
+					}
+				}
+				t.Segments = append(t.Segments, coords)
+			}
+
+			stack = stack[:len(stack)-1]
+			text.Reset()
+		}
+	}
+
+	return t, nil
+}
+
+// parseKmlCoordinates parses a <coordinates> body: whitespace separated
+// "lon,lat[,alt]" tuples.
+func parseKmlCoordinates(s string) []TrackPoint {
+	var pts []TrackPoint
+	for _, tuple := range strings.Fields(s) {
+		if pt, ok := parseKmlTuple(strings.Split(tuple, ",")); ok {
+			pts = append(pts, pt)
+		}
+	}
+	return pts
+}
+
+func parseKmlTuple(parts []string) (TrackPoint, bool) {
+	if len(parts) < 2 {
+		return TrackPoint{}, false
+	}
+	lon, err1 := strconv.ParseFloat(parts[0], 64)
+	lat, err2 := strconv.ParseFloat(parts[1], 64)
+	if err1 != nil || err2 != nil {
+		return TrackPoint{}, false
+	}
+	pt := TrackPoint{Lat: lat, Lon: lon}
+	if len(parts) > 2 {
+		if ele, err := strconv.ParseFloat(parts[2], 64); err == nil {
+			pt.Ele = ele
+			pt.HasEle = true
+		}
+	}
+	return pt, true
+}
+
+// parseKmz reads the main KML document from a KMZ archive. By convention it
+// is doc.kml, otherwise the first .kml entry is used.
+func parseKmz(data []byte) (*Track, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	var doc *zip.File
+	for _, f := range zr.File {
+		if !strings.HasSuffix(strings.ToLower(f.Name), ".kml") {
+			continue
+		}
+		if doc == nil || f.Name == "doc.kml" {
+			doc = f
+		}
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("no KML document in archive")
+	}
+
+	rc, err := doc.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	kml, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	return parseKml(kml)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fitSemicircle is the inverse of fitSemicircles.
+func fitSemicircle(deg float64) uint32 {
+	return uint32(int32(math.Round(deg * 2147483648.0 / 180.0)))
+}
+
+// fitFixture is a record-only activity: one record with a full timestamp,
+// then two with compressed timestamp headers, the second wrapping the
+// 32-second rollover.
+func fitFixture() []byte {
+	le := binary.LittleEndian
+	var body bytes.Buffer
+	u32 := func(v uint32) { binary.Write(&body, le, v) }
+
+	// Local 0: record with timestamp, lat, lon
+	body.Write([]byte{0x40, 0, 0, fitMesgRecord, 0, 3,
+		fitFieldTimestamp, 4, 0x86,
+		fitFieldPositionLat, 4, 0x85,
+		fitFieldPositionLong, 4, 0x85})
+	body.WriteByte(0x00)
+	u32(1000) // 1000 & 0x1F = 8
+	u32(fitSemicircle(44.71))
+	u32(fitSemicircle(9.18))
+
+	// Local 1: record with lat, lon only, timed by compressed headers
+	body.Write([]byte{0x41, 0, 0, fitMesgRecord, 0, 2,
+		fitFieldPositionLat, 4, 0x85,
+		fitFieldPositionLong, 4, 0x85})
+	body.WriteByte(0x80 | 1<<5 | 10) // +2 s
+	u32(fitSemicircle(44.72))
+	u32(fitSemicircle(9.19))
+	body.WriteByte(0x80 | 1<<5 | 3) // Wraps: +25 s
+	u32(fitSemicircle(44.73))
+	u32(fitSemicircle(9.20))
+
+	var file bytes.Buffer
+	file.Write([]byte{14, 0x10})
+	binary.Write(&file, le, uint16(2132))
+	binary.Write(&file, le, uint32(body.Len()))
+	file.WriteString(".FIT")
+	file.Write([]byte{0, 0}) // Header CRC, not checked
+	file.Write(body.Bytes())
+	file.Write([]byte{0, 0}) // File CRC
+	return file.Bytes()
+}
+
+func TestParseFitCompressedTimestamps(t *testing.T) {
+	data := fitFixture()
+	if got := sniffTrackFormat(data); got != trackFormatFIT {
+		t.Fatalf("sniffTrackFormat = %q, want fit", got)
+	}
+	track, err := parseFit(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(track.Segments) != 1 {
+		t.Fatalf("got %d segments, want 1", len(track.Segments))
+	}
+	pts := track.Points()
+	if len(pts) != 3 {
+		t.Fatalf("got %d points, want 3", len(pts))
+	}
+	for i, want := range []uint32{1000, 1002, 1027} {
+		if ts := fitEpoch.Add(time.Duration(want) * time.Second); !pts[i].Time.Equal(ts) {
+			t.Errorf("point %d time = %v, want %v", i, pts[i].Time, ts)
+		}
+	}
+	if math.Abs(pts[2].Lat-44.73) > 1e-6 || math.Abs(pts[2].Lon-9.20) > 1e-6 {
+		t.Errorf("point 2 = %v,%v, want 44.73,9.20", pts[2].Lat, pts[2].Lon)
+	}
+}
+
+const kmlTrackFixture = `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2" xmlns:gx="http://www.google.com/kml/ext/2.2">
+<Document>
+  <name>Giro del Chiappo</name>
+  <Placemark>
+    <gx:Track>
+      <when>2026-01-02T10:00:00Z</when>
+      <when>2026-01-02T10:01:00Z</when>
+      <when>2026-01-02T10:02:00Z</when>
+      <gx:coord>9.18 44.71 1000</gx:coord>
+      <gx:coord>9.19 44.72 1010</gx:coord>
+      <gx:coord>9.20 44.73 1020</gx:coord>
+    </gx:Track>
+  </Placemark>
+  <Placemark>
+    <LineString>
+      <coordinates>9.20,44.73,1020 9.21,44.74,1030</coordinates>
+    </LineString>
+  </Placemark>
+</Document>
+</kml>`
+
+func TestParseKmzPrefersDocKml(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	// An overlay listed first must not win over doc.kml
+	for _, f := range []struct{ name, body string }{
+		{"files/overlay.kml", `<kml><Document><name>Overlay</name><Placemark><LineString><coordinates>1,1 2,2</coordinates></LineString></Placemark></Document></kml>`},
+		{"doc.kml", kmlTrackFixture},
+	} {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(f.body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "track.kmz")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	track, err := loadTrack(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if track.Format != trackFormatKMZ || track.Name != "Giro del Chiappo" {
+		t.Errorf("format, name = %q, %q, want kmz, Giro del Chiappo", track.Format, track.Name)
+	}
+	if len(track.Segments) != 2 {
+		t.Fatalf("got %d segments, want 2", len(track.Segments))
+	}
+	if n := len(track.Segments[0]); n != 3 {
+		t.Errorf("gx:Track has %d points, want 3", n)
+	}
+	if n := len(track.Segments[1]); n != 2 {
+		t.Errorf("LineString has %d points, want 2", n)
+	}
+	first := track.Segments[0][0]
+	if !first.Time.Equal(time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)) || first.Ele != 1000 {
+		t.Errorf("first point = %+v", first)
+	}
+}
+
+func TestLoadGeoJSONDropsEmptyLines(t *testing.T) {
+	data := []byte(`{"type": "Feature", "properties": {"name": "Pineta"},
+		"geometry": {"type": "MultiLineString", "coordinates": [
+			[[9.18, 44.71, 1000], [9.19, 44.72, 1010]],
+			[],
+			[[9.20, 44.73], [9.21, 44.74], [9.22, 44.75]]
+		]}}`)
+
+	track, err := parseGeoJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(track.Segments) != 3 {
+		t.Fatalf("parseGeoJSON: got %d segments, want 3", len(track.Segments))
+	}
+
+	path := filepath.Join(t.TempDir(), "pineta.geojson")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	track, err = loadTrack(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(track.Segments) != 2 {
+		t.Fatalf("loadTrack: got %d segments, want 2", len(track.Segments))
+	}
+	if n := len(track.Points()); n != 5 {
+		t.Errorf("got %d points, want 5", n)
+	}
+	if track.Name != "Pineta" || !track.Segments[0][0].HasEle || track.Segments[1][0].HasEle {
+		t.Errorf("unexpected track %+v", track)
+	}
+}
+
+func TestIsTrackSource(t *testing.T) {
+	for rel, want := range map[string]bool{
+		"gpx/jo/Pineta.gpx":     true,
+		"gpx/jo/giro.FIT":       true,
+		"gpx/sample.kmz":        true,
+		"gpx/readme.txt":        false,
+		"img/pineta.jpg":        false,
+		"webcam/panorama/a.jpg": false,
+		"js/tracks.geojson":     false,
+	} {
+		if got := isTrackSource(rel); got != want {
+			t.Errorf("isTrackSource(%q) = %v, want %v", rel, got, want)
+		}
+	}
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Local Weather Station
+//
+// Readings from the village's own station replace the Open-Meteo forecast
+// the webcam page used to fetch from the browser. They come in through
+// -update-weather (a file) or the daemon's /weather endpoint, as JSON in our
+// own format or as a CSV export from WeeWX or Ecowitt, and are converted to
+// metric units. static/weather/history.json keeps a rolling history;
+// dist/static/weather/weather.json is the summary the page reads.
+
+const (
+	weatherHistoryPath = "static/weather/history.json"
+	weatherDistDir     = "dist/static/weather"
+	weatherMaxUpload   = 5 << 20
+)
+
+type WeatherConfig struct {
+	HistoryDays       int `toml:"history_days"`        // Older readings are dropped
+	StaleAfterMinutes int `toml:"stale_after_minutes"` // Older readings are not shown as current conditions
+}
+
+// WeatherReading is one station reading. Fields the station does not report
+// are nil.
+type WeatherReading struct {
+	Time        time.Time `json:"time"`
+	Temperature *float64  `json:"temperature,omitempty"` // °C
+	Humidity    *float64  `json:"humidity,omitempty"`    // %
+	WindSpeed   *float64  `json:"wind_speed,omitempty"`  // km/h
+	WindGust    *float64  `json:"wind_gust,omitempty"`   // km/h
+	WindDir     *float64  `json:"wind_dir,omitempty"`    // Degrees, where the wind comes from
+	Pressure    *float64  `json:"pressure,omitempty"`    // hPa, sea level
+	Rain        *float64  `json:"rain,omitempty"`        // mm since the previous reading
+	RainDay     *float64  `json:"rain_day,omitempty"`    // mm since local midnight
+}
+
+// weatherSummary is weather.json.
+type weatherSummary struct {
+	Updated           string   `json:"updated,omitempty"` // RFC 3339 time of the latest reading
+	StaleAfterMinutes int      `json:"stale_after_minutes"`
+	Temperature       *float64 `json:"temperature,omitempty"`
+	FeelsLike         *float64 `json:"feels_like,omitempty"`
+	Humidity          *float64 `json:"humidity,omitempty"`
+	WindSpeed         *float64 `json:"wind_speed,omitempty"`
+	WindGust          *float64 `json:"wind_gust,omitempty"`
+	WindDir           *float64 `json:"wind_dir,omitempty"`
+	Pressure          *float64 `json:"pressure,omitempty"`
+	PressureTrend     *float64 `json:"pressure_trend,omitempty"` // hPa change over the last 3 hours
+	RainToday         *float64 `json:"rain_today,omitempty"`     // mm
+}
+
+func handleWeatherUpdate(path string) {
+	site, err := loadSite("content/site.toml")
+	if err != nil {
+		log.Fatalf("Error loading site config: %v", err)
+	}
+	var data []byte
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		log.Fatalf("Error reading weather readings: %v", err)
+	}
+	readings, err := parseWeatherReadings(data, site.Location)
+	if err != nil {
+		log.Fatalf("Error parsing weather readings: %v", err)
+	}
+	if err := ingestWeatherReadings(site, readings); err != nil {
+		log.Fatalf("Error updating weather: %v", err)
+	}
+	fmt.Printf("Weather update complete: %d reading(s).\n", len(readings))
+}
+
+// ingestWeatherReadings merges readings into the history and rewrites it
+// (static and dist copies) and weather.json, under the site lock.
+func ingestWeatherReadings(site *SiteConfig, readings []WeatherReading) error {
+	unlock, err := lockSite()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	history, err := loadWeatherHistory(weatherHistoryPath)
+	if err != nil {
+		return fmt.Errorf("loading weather history: %w", err)
+	}
+	history = mergeWeatherReadings(history, readings, time.Now().AddDate(0, 0, -site.Weather.HistoryDays))
+
+	b, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	for _, path := range []string{weatherHistoryPath, filepath.Join(weatherDistDir, "history.json")} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path, b); err != nil {
+			return fmt.Errorf("writing %s: %w", path, err)
+		}
+	}
+	return writeWeatherSummary(site, history)
+}
+
+// weatherUploadHandler accepts readings POSTed to the daemon in any format
+// -update-weather reads, with its own bearer token ($BRUGGI_WEATHER_TOKEN).
+func weatherUploadHandler(site *SiteConfig, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, weatherMaxUpload))
+		if err != nil {
+			http.Error(w, "upload failed: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		readings, err := parseWeatherReadings(data, site.Location)
+		if err != nil {
+			http.Error(w, "invalid readings: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := ingestWeatherReadings(site, readings); err != nil {
+			log.Printf("Error updating weather: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Weather: ingested %d reading(s)", len(readings))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// writeWeather writes weather.json from the history, for the build.
+func writeWeather(site *SiteConfig) error {
+	history, err := loadWeatherHistory(weatherHistoryPath)
+	if err != nil {
+		return err
+	}
+	return writeWeatherSummary(site, history)
+}
+
+func loadWeatherHistory(path string) ([]WeatherReading, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var history []WeatherReading
+	if err := json.Unmarshal(b, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// mergeWeatherReadings adds readings to history, oldest first. A reading
+// replaces the one with the same time (a file sent twice), readings older
+// than cutoff are dropped.
+func mergeWeatherReadings(history, readings []WeatherReading, cutoff time.Time) []WeatherReading {
+	byTime := make(map[int64]WeatherReading)
+	for _, r := range append(history, readings...) {
+		if !r.Time.Before(cutoff) {
+			byTime[r.Time.Unix()] = r
+		}
+	}
+	out := make([]WeatherReading, 0, len(byTime))
+	for _, r := range byTime {
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	return out
+}
+
+func writeWeatherSummary(site *SiteConfig, history []WeatherReading) error {
+	sum := weatherSummary{StaleAfterMinutes: site.Weather.StaleAfterMinutes}
+	if len(history) > 0 {
+		last := history[len(history)-1]
+		sum.Updated = last.Time.Format(time.RFC3339)
+		sum.Temperature, sum.Humidity, sum.Pressure = last.Temperature, last.Humidity, last.Pressure
+		sum.WindSpeed, sum.WindGust, sum.WindDir = last.WindSpeed, last.WindGust, last.WindDir
+		if last.Temperature != nil {
+			sum.FeelsLike = roundedPtr(feelsLike(*last.Temperature, last.WindSpeed, last.Humidity), 1)
+		}
+		sum.PressureTrend = pressureTrend(history)
+		sum.RainToday = rainToday(history, time.Now().In(site.Location))
+	}
+
+	b, err := json.MarshalIndent(sum, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(weatherDistDir, 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(weatherDistDir, "weather.json"), b)
+}
+
+// feelsLike is the wind chill in the cold and the heat index in the heat,
+// the formulas weather services use, otherwise the temperature itself.
+func feelsLike(t float64, wind *float64, humidity *float64) float64 {
+	switch {
+	case t <= 10 && wind != nil && *wind > 4.8:
+		v := math.Pow(*wind, 0.16)
+		return 13.12 + 0.6215*t - 11.37*v + 0.3965*t*v
+	case t >= 27 && humidity != nil:
+		// Rothfusz regression, in °F
+		f, h := t*9/5+32, *humidity
+		hi := -42.379 + 2.04901523*f + 10.14333127*h - 0.22475541*f*h - 6.83783e-3*f*f -
+			5.481717e-2*h*h + 1.22874e-3*f*f*h + 8.5282e-4*f*h*h - 1.99e-6*f*f*h*h
+		return (hi - 32) * 5 / 9
+	}
+	return t
+}
+
+// pressureTrend compares the latest pressure with the one about 3 hours
+// earlier, nil without a reading within half an hour of that.
+func pressureTrend(history []WeatherReading) *float64 {
+	last := history[len(history)-1]
+	if last.Pressure == nil {
+		return nil
+	}
+	target := last.Time.Add(-3 * time.Hour)
+	var best *WeatherReading
+	for i := range history {
+		r := &history[i]
+		if r.Pressure == nil || r.Time.Sub(target).Abs() > 30*time.Minute {
+			continue
+		}
+		if best == nil || r.Time.Sub(target).Abs() < best.Time.Sub(target).Abs() {
+			best = r
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return roundedPtr(*last.Pressure-*best.Pressure, 1)
+}
+
+// rainToday is the station's daily total when it reports one, otherwise the
+// sum of today's per-reading amounts, nil when the last reading is from an
+// earlier day. Today is that of now's location, the site timezone.
+func rainToday(history []WeatherReading, now time.Time) *float64 {
+	y, m, d := now.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	last := history[len(history)-1]
+	if last.Time.Before(midnight) {
+		return nil
+	}
+	if last.RainDay != nil {
+		return last.RainDay
+	}
+	var total float64
+	found := false
+	for _, r := range history {
+		if r.Rain != nil && !r.Time.Before(midnight) {
+			total += *r.Rain
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return roundedPtr(total, 1)
+}
+
+func roundedPtr(v float64, decimals int) *float64 {
+	p := math.Pow(10, float64(decimals))
+	r := math.Round(v*p) / p
+	return &r
+}
+
+// Parsing
+
+// parseWeatherReadings reads a JSON reading or array of readings, or a WeeWX
+// or Ecowitt CSV export. Times without a zone (Ecowitt) are in loc.
+func parseWeatherReadings(data []byte, loc *time.Location) ([]WeatherReading, error) {
+	data = bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	var readings []WeatherReading
+	switch {
+	case len(data) == 0:
+		return nil, fmt.Errorf("no readings")
+	case data[0] == '{':
+		var r WeatherReading
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, err
+		}
+		readings = []WeatherReading{r}
+	case data[0] == '[':
+		if err := json.Unmarshal(data, &readings); err != nil {
+			return nil, err
+		}
+	default:
+		rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) < 2 {
+			return nil, fmt.Errorf("no readings")
+		}
+		header := make(map[string]int)
+		for i, name := range rows[0] {
+			header[strings.TrimSpace(name)] = i
+		}
+		if _, ok := header["dateTime"]; ok {
+			readings, err = parseWeeWXRows(header, rows[1:])
+		} else {
+			readings, err = parseEcowittRows(rows[0], rows[1:], loc)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, r := range readings {
+		if r.Time.IsZero() {
+			return nil, fmt.Errorf("reading %d has no time", i+1)
+		}
+	}
+	return readings, nil
+}
+
+// weewxUnits are the units of each WeeWX unit system (usUnits): 1 US,
+// 16 METRIC, 17 METRICWX.
+var weewxUnits = map[int]map[string]string{
+	1:  {"temp": "f", "speed": "mph", "pressure": "inhg", "rain": "in"},
+	16: {"temp": "c", "speed": "km/h", "pressure": "hpa", "rain": "cm"},
+	17: {"temp": "c", "speed": "m/s", "pressure": "hpa", "rain": "mm"},
+}
+
+// parseWeeWXRows reads the archive columns WeeWX exports (weectl, the CSV
+// extension): dateTime in Unix seconds and values in the row's usUnits.
+func parseWeeWXRows(header map[string]int, rows [][]string) ([]WeatherReading, error) {
+	var readings []WeatherReading
+	for n, row := range rows {
+		field := func(name string) (float64, bool) {
+			i, ok := header[name]
+			if !ok || i >= len(row) {
+				return 0, false
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(row[i]), 64)
+			return v, err == nil // Empty and "None" are missing values
+		}
+		epoch, ok := field("dateTime")
+		if !ok {
+			return nil, fmt.Errorf("row %d: invalid dateTime", n+2)
+		}
+		system := 1
+		if v, ok := field("usUnits"); ok {
+			system = int(v)
+		}
+		units, ok := weewxUnits[system]
+		if !ok {
+			return nil, fmt.Errorf("row %d: unknown usUnits %d", n+2, system)
+		}
+		value := func(name string, kind string) *float64 {
+			v, ok := field(name)
+			if !ok {
+				return nil
+			}
+			return roundedPtr(toMetric(v, units[kind]), 2)
+		}
+		readings = append(readings, WeatherReading{
+			Time:        time.Unix(int64(epoch), 0).UTC(),
+			Temperature: value("outTemp", "temp"),
+			Humidity:    value("outHumidity", ""),
+			WindSpeed:   value("windSpeed", "speed"),
+			WindGust:    value("windGust", "speed"),
+			WindDir:     value("windDir", ""),
+			Pressure:    value("barometer", "pressure"),
+			Rain:        value("rain", "rain"),
+			RainDay:     value("dayRain", "rain"),
+		})
+	}
+	return readings, nil
+}
+
+// ecowittColumns are the header names (before the unit) of the Ecowitt
+// exports, from WS View and ecowitt.net, in order of preference.
+var ecowittColumns = map[string][]string{
+	"time":     {"time"},
+	"temp":     {"outdoor temperature", "temperature"},
+	"humidity": {"outdoor humidity", "humidity"},
+	"wind":     {"wind speed", "wind"},
+	"gust":     {"gust", "wind gust"},
+	"dir":      {"wind direction"},
+	"pressure": {"rel pressure", "relative pressure", "rel. pressure"},
+	"rainDay":  {"daily rain", "daily"},
+}
+
+var ecowittTimeFormats = []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006/1/2 15:04:05", "2006/1/2 15:04"}
+
+// parseEcowittRows reads an Ecowitt CSV export: local times and units in the
+// column names, e.g. "Outdoor Temperature(℃)".
+func parseEcowittRows(names []string, rows [][]string, loc *time.Location) ([]WeatherReading, error) {
+	type column struct {
+		index int
+		unit  string
+	}
+	columns := make(map[string]column)
+	for key, candidates := range ecowittColumns {
+		for _, want := range candidates {
+			for i, name := range names {
+				label, unit, _ := strings.Cut(strings.ToLower(strings.TrimSpace(name)), "(")
+				if strings.TrimSpace(label) == want {
+					columns[key] = column{i, strings.TrimSuffix(unit, ")")}
+					break
+				}
+			}
+			if _, ok := columns[key]; ok {
+				break
+			}
+		}
+	}
+	timeCol, ok := columns["time"]
+	if !ok {
+		return nil, fmt.Errorf("unknown CSV format: no dateTime (WeeWX) or Time (Ecowitt) column")
+	}
+
+	var readings []WeatherReading
+	for n, row := range rows {
+		if timeCol.index >= len(row) {
+			continue
+		}
+		var t time.Time
+		var err error
+		for _, layout := range ecowittTimeFormats {
+			if t, err = time.ParseInLocation(layout, strings.TrimSpace(row[timeCol.index]), loc); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid time %q", n+2, row[timeCol.index])
+		}
+		value := func(key string) *float64 {
+			c, ok := columns[key]
+			if !ok || c.index >= len(row) {
+				return nil
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(row[c.index]), 64)
+			if err != nil {
+				return nil // "--" when a sensor is offline
+			}
+			return roundedPtr(toMetric(v, c.unit), 2)
+		}
+		readings = append(readings, WeatherReading{
+			Time:        t.UTC(),
+			Temperature: value("temp"),
+			Humidity:    value("humidity"),
+			WindSpeed:   value("wind"),
+			WindGust:    value("gust"),
+			WindDir:     value("dir"),
+			Pressure:    value("pressure"),
+			RainDay:     value("rainDay"),
+		})
+	}
+	return readings, nil
+}
+
+// toMetric converts v from unit to °C, km/h, hPa or mm. Metric and unknown
+// units are returned as they are.
+func toMetric(v float64, unit string) float64 {
+	switch strings.TrimSpace(strings.ToLower(unit)) {
+	case "f", "°f", "℉":
+		return (v - 32) * 5 / 9
+	case "mph":
+		return v * 1.609344
+	case "m/s":
+		return v * 3.6
+	case "knots", "kn", "kt":
+		return v * 1.852
+	case "inhg":
+		return v * 33.8639
+	case "mmhg":
+		return v * 1.333224
+	case "in":
+		return v * 25.4
+	case "cm":
+		return v * 10
+	}
+	return v
+}
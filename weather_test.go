@@ -0,0 +1,247 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Fixed zone, so the tests do not depend on the host's tzdata
+var weatherTestLoc = time.FixedZone("CET", 3600)
+
+func ptr(v float64) *float64 { return &v }
+
+// checkValue compares an optional reading value, nil meaning missing.
+func checkValue(t *testing.T, name string, got *float64, want *float64) {
+	t.Helper()
+	switch {
+	case got == nil && want == nil:
+	case got == nil || want == nil:
+		t.Errorf("%s = %v, want %v", name, got, want)
+	case math.Abs(*got-*want) > 0.01:
+		t.Errorf("%s = %v, want %v", name, *got, *want)
+	}
+}
+
+func TestParseWeatherReadings(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		data string
+		want []WeatherReading
+	}{
+		{
+			name: "json object",
+			data: `{"time": "2026-01-01T12:00:00+01:00", "temperature": 4.5, "pressure": 1012.3}`,
+			want: []WeatherReading{{Time: time.Date(2026, 1, 1, 11, 0, 0, 0, time.UTC), Temperature: ptr(4.5), Pressure: ptr(1012.3)}},
+		},
+		{
+			name: "json array",
+			data: "\xef\xbb\xbf" + `[{"time": "2026-01-01T11:00:00Z", "rain": 0.2}, {"time": "2026-01-01T11:05:00Z", "rain_day": 1.4}]`,
+			want: []WeatherReading{
+				{Time: time.Date(2026, 1, 1, 11, 0, 0, 0, time.UTC), Rain: ptr(0.2)},
+				{Time: time.Date(2026, 1, 1, 11, 5, 0, 0, time.UTC), RainDay: ptr(1.4)},
+			},
+		},
+		{
+			name: "weewx us",
+			data: "dateTime,usUnits,outTemp,outHumidity,windSpeed,windGust,windDir,barometer,rain,dayRain\n" +
+				"1767265200,1,50,80,10,20,270,30.00,0.01,0.10\n",
+			want: []WeatherReading{{
+				Time: time.Unix(1767265200, 0), Temperature: ptr(10), Humidity: ptr(80),
+				WindSpeed: ptr(16.09), WindGust: ptr(32.19), WindDir: ptr(270),
+				Pressure: ptr(1015.92), Rain: ptr(0.25), RainDay: ptr(2.54),
+			}},
+		},
+		{
+			name: "weewx metric",
+			data: "dateTime,usUnits,outTemp,outHumidity,windSpeed,windGust,windDir,barometer,rain,dayRain\n" +
+				"1767265200,16,10,80,16,32,None,1015.9,0.1,0.5\n",
+			want: []WeatherReading{{
+				Time: time.Unix(1767265200, 0), Temperature: ptr(10), Humidity: ptr(80),
+				WindSpeed: ptr(16), WindGust: ptr(32), Pressure: ptr(1015.9), Rain: ptr(1), RainDay: ptr(5),
+			}},
+		},
+		{
+			name: "ecowitt",
+			data: "Time,Outdoor Temperature(℉),Outdoor Humidity(%),Wind(mph),Gust(mph),Wind Direction(°),Rel Pressure(inHg),Daily Rain(in)\n" +
+				"2026-01-01 12:00:00,50,70,10,--,180,30.00,0.10\n" +
+				"2026/1/1 12:05,50.9,71,5,10,190,30.01,0.10\n",
+			want: []WeatherReading{
+				{
+					Time: time.Date(2026, 1, 1, 11, 0, 0, 0, time.UTC), Temperature: ptr(10), Humidity: ptr(70),
+					WindSpeed: ptr(16.09), WindDir: ptr(180), Pressure: ptr(1015.92), RainDay: ptr(2.54),
+				},
+				{
+					Time: time.Date(2026, 1, 1, 11, 5, 0, 0, time.UTC), Temperature: ptr(10.5), Humidity: ptr(71),
+					WindSpeed: ptr(8.05), WindGust: ptr(16.09), WindDir: ptr(190), Pressure: ptr(1016.26), RainDay: ptr(2.54),
+				},
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseWeatherReadings([]byte(c.data), weatherTestLoc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(c.want) {
+				t.Fatalf("got %d readings, want %d", len(got), len(c.want))
+			}
+			for i, w := range c.want {
+				g := got[i]
+				if !g.Time.Equal(w.Time) {
+					t.Errorf("reading %d time = %v, want %v", i, g.Time, w.Time)
+				}
+				checkValue(t, "temperature", g.Temperature, w.Temperature)
+				checkValue(t, "humidity", g.Humidity, w.Humidity)
+				checkValue(t, "wind_speed", g.WindSpeed, w.WindSpeed)
+				checkValue(t, "wind_gust", g.WindGust, w.WindGust)
+				checkValue(t, "wind_dir", g.WindDir, w.WindDir)
+				checkValue(t, "pressure", g.Pressure, w.Pressure)
+				checkValue(t, "rain", g.Rain, w.Rain)
+				checkValue(t, "rain_day", g.RainDay, w.RainDay)
+			}
+		})
+	}
+
+	for _, data := range []string{
+		"",
+		`{"temperature": 4.5}`,
+		"Date,Temp\n2026-01-01,4.5\n",
+		"dateTime,usUnits,outTemp\n1767265200,99,50\n",
+	} {
+		if _, err := parseWeatherReadings([]byte(data), weatherTestLoc); err == nil {
+			t.Errorf("parseWeatherReadings(%q): no error", data)
+		}
+	}
+}
+
+func TestMergeWeatherReadings(t *testing.T) {
+	at := func(minute int) time.Time { return time.Date(2026, 1, 1, 12, minute, 0, 0, time.UTC) }
+	history := []WeatherReading{
+		{Time: at(0), Temperature: ptr(1)},
+		{Time: at(10), Temperature: ptr(2)},
+	}
+	readings := []WeatherReading{
+		{Time: at(10).In(weatherTestLoc), Temperature: ptr(3)}, // Sent again, same instant
+		{Time: at(5), Temperature: ptr(4)},
+		{Time: at(-30), Temperature: ptr(5)}, // Before the cutoff
+	}
+	got := mergeWeatherReadings(history, readings, at(-10))
+
+	want := []float64{1, 4, 3}
+	if len(got) != len(want) {
+		t.Fatalf("got %d readings, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		if *got[i].Temperature != w {
+			t.Errorf("reading %d temperature = %v, want %v", i, *got[i].Temperature, w)
+		}
+	}
+	if !got[0].Time.Equal(at(0)) || !got[1].Time.Equal(at(5)) || !got[2].Time.Equal(at(10)) {
+		t.Errorf("readings out of order: %v, %v, %v", got[0].Time, got[1].Time, got[2].Time)
+	}
+}
+
+func TestPressureTrend(t *testing.T) {
+	last := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	reading := func(ago time.Duration, p *float64) WeatherReading {
+		return WeatherReading{Time: last.Add(-ago), Pressure: p}
+	}
+	for _, c := range []struct {
+		name    string
+		history []WeatherReading
+		want    *float64
+	}{
+		{"closest to 3 hours", []WeatherReading{
+			reading(3*time.Hour+25*time.Minute, ptr(1000)),
+			reading(3*time.Hour+10*time.Minute, ptr(1010)),
+			reading(2*time.Hour, ptr(1011)),
+			reading(0, ptr(1013)),
+		}, ptr(3)},
+		{"falling", []WeatherReading{
+			reading(2*time.Hour+40*time.Minute, ptr(1015.5)),
+			reading(0, ptr(1013)),
+		}, ptr(-2.5)},
+		{"nothing near 3 hours", []WeatherReading{
+			reading(4*time.Hour, ptr(1010)),
+			reading(time.Hour, ptr(1011)),
+			reading(0, ptr(1013)),
+		}, nil},
+		{"no pressure then", []WeatherReading{
+			reading(3*time.Hour, nil),
+			reading(0, ptr(1013)),
+		}, nil},
+		{"no pressure now", []WeatherReading{
+			reading(3*time.Hour, ptr(1010)),
+			reading(0, nil),
+		}, nil},
+	} {
+		checkValue(t, c.name, pressureTrend(c.history), c.want)
+	}
+}
+
+func TestRainToday(t *testing.T) {
+	now := time.Date(2026, 1, 2, 8, 0, 0, 0, weatherTestLoc)
+	at := func(day, hour int) time.Time { return time.Date(2026, 1, day, hour, 0, 0, 0, weatherTestLoc) }
+	for _, c := range []struct {
+		name    string
+		history []WeatherReading
+		want    *float64
+	}{
+		{"daily total", []WeatherReading{
+			{Time: at(1, 23), RainDay: ptr(5)},
+			{Time: at(2, 7), RainDay: ptr(1.2)},
+		}, ptr(1.2)},
+		{"sum since midnight", []WeatherReading{
+			{Time: at(1, 23), Rain: ptr(5)},
+			{Time: at(2, 1), Rain: ptr(0.4)},
+			{Time: at(2, 7), Rain: ptr(0.3)},
+		}, ptr(0.7)},
+		{"last reading yesterday", []WeatherReading{
+			{Time: at(1, 22), Rain: ptr(0.4)},
+			{Time: at(1, 23), RainDay: ptr(5)},
+		}, nil},
+		{"no rain reported", []WeatherReading{
+			{Time: at(2, 7), Temperature: ptr(3)},
+		}, nil},
+	} {
+		checkValue(t, c.name, rainToday(c.history, now), c.want)
+	}
+}
+
+func TestWeatherUploadHandler(t *testing.T) {
+	site := &SiteConfig{Location: weatherTestLoc}
+	handler := weatherUploadHandler(site, "secret")
+	for _, c := range []struct {
+		name   string
+		method string
+		auth   string
+		body   string
+		want   int
+	}{
+		{"get", http.MethodGet, "Bearer secret", "", http.StatusMethodNotAllowed},
+		{"put", http.MethodPut, "Bearer secret", `{"time": "2026-01-01T12:00:00Z"}`, http.StatusMethodNotAllowed},
+		{"no token", http.MethodPost, "", `{"time": "2026-01-01T12:00:00Z"}`, http.StatusUnauthorized},
+		{"wrong token", http.MethodPost, "Bearer secreT", `{"time": "2026-01-01T12:00:00Z"}`, http.StatusUnauthorized},
+		{"not bearer", http.MethodPost, "Basic secret", `{"time": "2026-01-01T12:00:00Z"}`, http.StatusUnauthorized},
+		{"invalid readings", http.MethodPost, "Bearer secret", "not,a,station\n1,2,3\n", http.StatusBadRequest},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(c.method, "/weather", strings.NewReader(c.body))
+			if c.auth != "" {
+				req.Header.Set("Authorization", c.auth)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != c.want {
+				t.Errorf("status = %d, want %d", rec.Code, c.want)
+			}
+			if c.want == http.StatusMethodNotAllowed && rec.Header().Get("Allow") != http.MethodPost {
+				t.Errorf("Allow = %q, want POST", rec.Header().Get("Allow"))
+			}
+		})
+	}
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// Webcam Archive Retention
+//
+// Every update adds a timestamped snapshot. Older snapshots are thinned out
+// so the archive (and the time-lapse embedding it) stays bounded: all of the
+// recent ones, then one per hour, then one per day, then none.
+
+// webcamTimeFormat names archived snapshots, in the site timezone whatever the
+// host's, as the camera names its frames.
+const webcamTimeFormat = "2006-01-02_15-04-05"
+
+type WebcamConfig struct {
+	KeepAllHours int `toml:"keep_all_hours"` // Every snapshot younger than this is kept
+	HourlyDays   int `toml:"hourly_days"`    // Then the first snapshot of each hour
+	DailyDays    int `toml:"daily_days"`     // Then the one closest to noon of each day, older ones are deleted
+
+	WebWidth   int    `toml:"web_width"`   // Pixels, the version shown on the site
+	ThumbWidth int    `toml:"thumb_width"` // Pixels, the frame strip
+	Watermark  string `toml:"watermark"`   // Drawn next to the capture time
+
+	TimelapseWidth  int `toml:"timelapse_width"`  // Pixels
+	TimelapseFrames int `toml:"timelapse_frames"` // Max frames per clip, evenly sampled
+
+	// Frame validation, on a 0-255 luma scale
+	MinBrightness       float64 `toml:"min_brightness"`        // Mean luma, darker frames are rejected (night)
+	MinContrast         float64 `toml:"min_contrast"`          // Luma std dev, flatter frames are rejected (fog, covered lens)
+	FrozenThreshold     float64 `toml:"frozen_threshold"`      // Mean luma difference to the previous good frame, below it the camera is stuck
+	QuarantineDir       string  `toml:"quarantine_dir"`        // Rejected frames, not published
+	OfflineAfterMinutes int     `toml:"offline_after_minutes"` // Without a good frame for this long the camera is shown offline
+
+	CaptureIntervalMinutes int `toml:"capture_interval_minutes"` // How often the camera sends a frame, for the next update time
+
+	// -webcam-daemon
+	IncomingDir     string  `toml:"incoming_dir"`     // Watched for new frames
+	Listen          string  `toml:"listen"`           // HTTP upload address, empty disables
+	DebounceSeconds float64 `toml:"debounce_seconds"` // Quiet time before a batch of frames is processed
+}
+
+// WebcamCamera is a [[webcams]] entry. Each camera has its own archive in
+// static/webcam/<id>/ (served from dist/static/webcam/<id>/) and its own page.
+type WebcamCamera struct {
+	ID  string             `toml:"id"` // Lowercase letters, digits and dashes
+	Lat float64            `toml:"lat"`
+	Lon float64            `toml:"lon"`
+	It  WebcamCameraLocale `toml:"it"`
+	En  WebcamCameraLocale `toml:"en"`
+
+	// Retention, the [webcam] values for those not set. Set to 0, a tier is
+	// skipped (hourly_days = 0: straight from every snapshot to one a day)
+	KeepAllHours *int `toml:"keep_all_hours"`
+	HourlyDays   *int `toml:"hourly_days"`
+	DailyDays    *int `toml:"daily_days"`
+
+	Page    string `toml:"-"` // /webcam.html for the first camera, /webcam/<id>.html for the others
+	Archive string `toml:"-"` // /webcam/archive for the first camera, /webcam/<id>/archive for the others
+}
+
+type WebcamCameraLocale struct {
+	Name     string `toml:"name"`
+	Location string `toml:"location"`
+}
+
+var webcamIDPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+func (c WebcamCamera) Dir() string     { return filepath.Join("static/webcam", c.ID) }
+func (c WebcamCamera) DistDir() string { return filepath.Join("dist/static/webcam", c.ID) }
+func (c WebcamCamera) Url() string     { return "/static/webcam/" + c.ID }
+
+func (c WebcamCamera) Locale(locale string) WebcamCameraLocale {
+	if locale == "it" {
+		return c.It
+	}
+	return c.En
+}
+
+// setupWebcams checks the [[webcams]] ids and assigns the pages.
+func setupWebcams(site *SiteConfig) error {
+	if len(site.Webcams) == 0 {
+		return fmt.Errorf("no [[webcams]] configured")
+	}
+	seen := make(map[string]bool)
+	for i := range site.Webcams {
+		cam := &site.Webcams[i]
+		if !webcamIDPattern.MatchString(cam.ID) {
+			return fmt.Errorf("invalid webcam id %q", cam.ID)
+		}
+		if seen[cam.ID] {
+			return fmt.Errorf("duplicate webcam id %q", cam.ID)
+		}
+		seen[cam.ID] = true
+		cam.Page, cam.Archive = "/webcam/"+cam.ID+".html", "/webcam/"+cam.ID+"/archive"
+		if i == 0 {
+			cam.Page, cam.Archive = "/webcam.html", "/webcam/archive"
+		}
+	}
+	return nil
+}
+
+// findWebcam returns the camera with the given id, the first one for "".
+func findWebcam(site *SiteConfig, id string) (WebcamCamera, error) {
+	if id == "" {
+		return site.Webcams[0], nil
+	}
+	for _, cam := range site.Webcams {
+		if cam.ID == id {
+			return cam, nil
+		}
+	}
+	return WebcamCamera{}, fmt.Errorf("unknown webcam %q", id)
+}
+
+// legacyWebcamCurrent is where current.jpg was before [[webcams]], linked
+// from elsewhere: it stays a copy of the first camera's.
+const legacyWebcamCurrent = "static/webcam/current.jpg"
+
+// writeLegacyWebcamCurrent copies the first camera's current.jpg to
+// legacyWebcamCurrent in static and dist. Nothing to copy is not an error.
+func writeLegacyWebcamCurrent(site *SiteConfig) error {
+	src := filepath.Join(site.Webcams[0].Dir(), "current.jpg")
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return nil
+	}
+	for _, dst := range []string{legacyWebcamCurrent, filepath.Join("dist", legacyWebcamCurrent)} {
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// For returns the settings that apply to cam: its own retention, where set,
+// and its own quarantine subdir.
+func (cfg WebcamConfig) For(cam WebcamCamera) WebcamConfig {
+	if cam.KeepAllHours != nil {
+		cfg.KeepAllHours = *cam.KeepAllHours
+	}
+	if cam.HourlyDays != nil {
+		cfg.HourlyDays = *cam.HourlyDays
+	}
+	if cam.DailyDays != nil {
+		cfg.DailyDays = *cam.DailyDays
+	}
+	cfg.QuarantineDir = filepath.Join(cfg.QuarantineDir, cam.ID)
+	return cfg
+}
+
+// webcamSnapshotName is the archive name of a frame taken at t.
+func webcamSnapshotName(t time.Time) string {
+	return t.Format(webcamTimeFormat) + ".jpg"
+}
+
+type webcamSnapshot struct {
+	Name string
+	Time time.Time
+}
+
+// webcamSnapshots lists the archived snapshots of dir, oldest first, with
+// their names read as times in loc. Files not named after webcamTimeFormat
+// (current.jpg) are not part of the archive.
+func webcamSnapshots(dir string, loc *time.Location) ([]webcamSnapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var snaps []webcamSnapshot
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(strings.ToLower(name), ".jpg") {
+			continue
+		}
+		t, err := time.ParseInLocation(webcamTimeFormat, strings.TrimSuffix(name, filepath.Ext(name)), loc)
+		if err != nil {
+			continue
+		}
+		snaps = append(snaps, webcamSnapshot{Name: name, Time: t})
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].Time.Before(snaps[j].Time) })
+	return snaps, nil
+}
+
+// retainedSnapshots applies the retention policy and returns the names to
+// keep. The choice within an hour or a day only depends on the snapshot
+// times, so repeated runs converge on one snapshot per hour or day.
+func retainedSnapshots(snaps []webcamSnapshot, cfg WebcamConfig, now time.Time) map[string]bool {
+	keepAll := now.Add(-time.Duration(cfg.KeepAllHours) * time.Hour)
+	hourly := now.AddDate(0, 0, -cfg.HourlyDays)
+	daily := now.AddDate(0, 0, -cfg.DailyDays)
+
+	keep := make(map[string]bool)
+	hours := make(map[string]bool)
+	days := make(map[string]webcamSnapshot)
+	for _, s := range snaps {
+		switch {
+		case !s.Time.Before(keepAll):
+			keep[s.Name] = true
+		case !s.Time.Before(hourly):
+			// Oldest first, so the first seen is the first of its hour
+			hour := s.Time.Format("2006-01-02 15")
+			if !hours[hour] {
+				hours[hour] = true
+				keep[s.Name] = true
+			}
+		case !s.Time.Before(daily):
+			// Daylight snapshots make a better archive than night ones
+			day := s.Time.Format(time.DateOnly)
+			if best, ok := days[day]; !ok || noonDistance(s.Time) < noonDistance(best.Time) {
+				days[day] = s
+			}
+		}
+	}
+	for _, s := range days {
+		keep[s.Name] = true
+	}
+	return keep
+}
+
+func noonDistance(t time.Time) time.Duration {
+	noon := time.Date(t.Year(), t.Month(), t.Day(), 12, 0, 0, 0, t.Location())
+	return t.Sub(noon).Abs()
+}
+
+// pruneWebcamArchive deletes the snapshots of each dir that the retention
+// policy drops. Dirs are pruned independently with the same now, so a
+// camera's static and dist dirs end up with the same files. Hours and days
+// are those of now's location, the site timezone.
+func pruneWebcamArchive(cfg WebcamConfig, now time.Time, dirs ...string) (int, error) {
+	removed := 0
+	for _, dir := range dirs {
+		snaps, err := webcamSnapshots(dir, now.Location())
+		if err != nil {
+			return removed, err
+		}
+		keep := retainedSnapshots(snaps, cfg, now)
+		for _, s := range snaps {
+			if keep[s.Name] {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, s.Name)); err != nil {
+				return removed, fmt.Errorf("removing %s: %w", s.Name, err)
+			}
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// Frame Validation
+//
+// Frames are checked before they are published: they must decode, be bright
+// and detailed enough, and differ from the previous good frame. Rejected
+// frames are kept in the quarantine dir for inspection.
+
+// webcamProbe is a small grayscale copy of a frame, enough for the checks.
+type webcamProbe []float64
+
+const webcamProbeW, webcamProbeH = 96, 54
+
+func probeWebcamFrame(path string) (webcamProbe, error) {
+	src, err := imaging.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return newWebcamProbe(src), nil
+}
+
+func newWebcamProbe(src image.Image) webcamProbe {
+	small := imaging.Grayscale(imaging.Resize(src, webcamProbeW, webcamProbeH, imaging.Box))
+	p := make(webcamProbe, 0, webcamProbeW*webcamProbeH)
+	for i := 0; i < len(small.Pix); i += 4 {
+		p = append(p, float64(small.Pix[i]))
+	}
+	return p
+}
+
+// checkWebcamFrame returns why a frame is rejected, or "" when it is good.
+// prev is the previous good frame, nil if there is none.
+func checkWebcamFrame(cfg WebcamConfig, p, prev webcamProbe) string {
+	var sum, sq float64
+	for _, v := range p {
+		sum += v
+	}
+	mean := sum / float64(len(p))
+	for _, v := range p {
+		sq += (v - mean) * (v - mean)
+	}
+	std := math.Sqrt(sq / float64(len(p)))
+
+	switch {
+	case mean < cfg.MinBrightness:
+		return "dark"
+	case std < cfg.MinContrast:
+		return "flat"
+	}
+	if prev != nil {
+		var diff float64
+		for i := range p {
+			diff += math.Abs(p[i] - prev[i])
+		}
+		if diff/float64(len(p)) < cfg.FrozenThreshold {
+			return "frozen"
+		}
+	}
+	return ""
+}
+
+// quarantineWebcamFrame keeps a copy of a rejected frame, named after its
+// time and the reason.
+func quarantineWebcamFrame(cfg WebcamConfig, frame webcamFrame, reason string) error {
+	if err := os.MkdirAll(cfg.QuarantineDir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s_%s.jpg", frame.Time.Format(webcamTimeFormat), reason)
+	return copyFile(frame.Path, filepath.Join(cfg.QuarantineDir, name))
+}
+
+// webcamHealth reports the time of the last good frame of a camera (the
+// newest archived snapshot, only good frames are archived) and whether it is
+// recent enough.
+func webcamHealth(site *SiteConfig, cam WebcamCamera, now time.Time) (last time.Time, online bool) {
+	snaps, err := webcamSnapshots(cam.Dir(), site.Location)
+	if err != nil || len(snaps) == 0 {
+		return time.Time{}, false
+	}
+	last = snaps[len(snaps)-1].Time
+	return last, now.Sub(last) <= time.Duration(site.Webcam.OfflineAfterMinutes)*time.Minute
+}
+
+// webcamRecentFrames is how many thumbnails the frame strip shows.
+const webcamRecentFrames = 12
+
+// WebcamFrameLink is an archived frame on the webcam page.
+type WebcamFrameLink struct {
+	Time  string // 15:04, or 02/01 15:04 for older days
+	Thumb string
+	Web   string
+}
+
+// WebcamCameraLink is an entry of the camera switcher.
+type WebcamCameraLink struct {
+	Name   string
+	Page   string
+	Active bool
+}
+
+// fillWebcamPage sets what the page of cam shows: the camera itself, the
+// other cameras, clips, the latest frames and the camera status.
+func fillWebcamPage(site *SiteConfig, cam WebcamCamera, locale string, page *RenderWebcamPage, now time.Time) {
+	l := cam.Locale(locale)
+	page.PanoramaTitle, page.Location = l.Name, l.Location
+	page.Camera, page.Lat, page.Lon = cam.Url(), cam.Lat, cam.Lon
+	for _, c := range site.Webcams {
+		page.Cameras = append(page.Cameras, WebcamCameraLink{Name: c.Locale(locale).Name, Page: c.Page, Active: c.ID == cam.ID})
+	}
+	page.Timelapses = loadTimelapses(cam)
+	page.ArchiveUrl = webcamArchiveUrl(site, cam)
+
+	snaps, _ := webcamSnapshots(cam.Dir(), site.Location)
+	for i := len(snaps) - 1; i >= 0 && len(page.Recent) < webcamRecentFrames; i-- {
+		s := snaps[i]
+		label := s.Time.Format("15:04")
+		if s.Time.Format(time.DateOnly) != now.In(site.Location).Format(time.DateOnly) {
+			label = s.Time.Format("02/01 15:04")
+		}
+		page.Recent = append(page.Recent, WebcamFrameLink{
+			Time:  label,
+			Thumb: cam.Url() + "/" + webcamThumbDir + "/" + s.Name,
+			Web:   cam.Url() + "/" + webcamWebDir + "/" + s.Name,
+		})
+	}
+
+	last, online := webcamHealth(site, cam, now)
+	page.Online = online
+	page.OfflineAfter = site.Webcam.OfflineAfterMinutes
+	if !last.IsZero() {
+		page.LastFrame = last.Format(time.RFC3339)
+	}
+}
+
+// Webcam Index
+//
+// dist/static/webcam/<id>/index.json describes the archive of a camera for
+// its page, which polls it to swap in new frames without reloading: the
+// latest frame, when the next one is due and every archived frame grouped by
+// day.
+
+type webcamIndex struct {
+	Latest              *webcamIndexFrame `json:"latest"` // Null with an empty archive
+	NextCapture         string            `json:"next_capture,omitempty"`
+	OfflineAfterMinutes int               `json:"offline_after_minutes"`
+	Days                []webcamIndexDay  `json:"days"` // Newest first
+}
+
+type webcamIndexDay struct {
+	Date   string             `json:"date"`   // 2006-01-02
+	Frames []webcamIndexFrame `json:"frames"` // Oldest first
+}
+
+type webcamIndexFrame struct {
+	Time     string `json:"time"` // RFC 3339
+	Url      string `json:"url"`  // Web version
+	Thumb    string `json:"thumb"`
+	Original string `json:"original"`
+}
+
+func writeWebcamIndex(site *SiteConfig, cam WebcamCamera) error {
+	snaps, err := webcamSnapshots(cam.Dir(), site.Location)
+	if err != nil {
+		return err
+	}
+	index := webcamIndex{OfflineAfterMinutes: site.Webcam.OfflineAfterMinutes, Days: []webcamIndexDay{}}
+	for i := len(snaps) - 1; i >= 0; i-- {
+		s := snaps[i]
+		frame := webcamIndexFrame{
+			Time:     s.Time.Format(time.RFC3339),
+			Url:      cam.Url() + "/" + webcamWebDir + "/" + s.Name,
+			Thumb:    cam.Url() + "/" + webcamThumbDir + "/" + s.Name,
+			Original: cam.Url() + "/" + s.Name,
+		}
+		if index.Latest == nil {
+			// The live view URLs, current.jpg is the latest frame
+			index.Latest = &webcamIndexFrame{
+				Time:     frame.Time,
+				Url:      cam.Url() + "/" + webcamWebDir + "/current.jpg",
+				Thumb:    cam.Url() + "/" + webcamThumbDir + "/current.jpg",
+				Original: cam.Url() + "/current.jpg",
+			}
+			index.NextCapture = s.Time.Add(time.Duration(site.Webcam.CaptureIntervalMinutes) * time.Minute).Format(time.RFC3339)
+		}
+		date := s.Time.Format(time.DateOnly)
+		if n := len(index.Days); n == 0 || index.Days[n-1].Date != date {
+			index.Days = append(index.Days, webcamIndexDay{Date: date})
+		}
+		day := &index.Days[len(index.Days)-1]
+		day.Frames = append([]webcamIndexFrame{frame}, day.Frames...)
+	}
+
+	b, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cam.DistDir(), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(cam.DistDir(), "index.json"), b)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func webcamTestLoc(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+// snapshotsAt lists snapshots taken at times, oldest first, named like the
+// archive names them.
+func snapshotsAt(times ...time.Time) []webcamSnapshot {
+	var snaps []webcamSnapshot
+	for _, tm := range times {
+		snaps = append(snaps, webcamSnapshot{Name: tm.Format(webcamTimeFormat) + ".jpg", Time: tm})
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].Time.Before(snaps[j].Time) })
+	return snaps
+}
+
+func TestRetainedSnapshots(t *testing.T) {
+	loc := webcamTestLoc(t)
+	at := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, loc)
+	}
+	cfg := WebcamConfig{KeepAllHours: 48, HourlyDays: 30, DailyDays: 365}
+
+	for _, c := range []struct {
+		name string
+		now  time.Time
+		cfg  WebcamConfig
+		in   []time.Time
+		keep []time.Time
+	}{
+		{
+			name: "keep all boundary",
+			now:  at(10, 19, 12, 30),
+			cfg:  cfg,
+			// 12:30 two days ago is the oldest kept snapshot, 12:10 is the
+			// first of its hour in the hourly tier, 12:20 goes
+			in:   []time.Time{at(10, 17, 12, 10), at(10, 17, 12, 20), at(10, 17, 12, 30), at(10, 17, 12, 40)},
+			keep: []time.Time{at(10, 17, 12, 10), at(10, 17, 12, 30), at(10, 17, 12, 40)},
+		},
+		{
+			name: "hourly boundary",
+			now:  at(10, 19, 12, 30),
+			cfg:  cfg,
+			// 12:30 thirty days ago is the oldest hourly one, the day's older
+			// snapshots are daily: the one closest to noon stays
+			in:   []time.Time{at(9, 19, 11, 50), at(9, 19, 12, 29), at(9, 19, 12, 30), at(9, 19, 12, 45)},
+			keep: []time.Time{at(9, 19, 11, 50), at(9, 19, 12, 30)},
+		},
+		{
+			name: "daily boundary",
+			now:  at(10, 19, 12, 30),
+			cfg:  cfg,
+			in: []time.Time{
+				time.Date(2025, 10, 19, 12, 29, 0, 0, loc),
+				time.Date(2025, 10, 19, 12, 30, 0, 0, loc),
+				time.Date(2025, 10, 18, 12, 0, 0, 0, loc),
+			},
+			keep: []time.Time{time.Date(2025, 10, 19, 12, 30, 0, 0, loc)},
+		},
+		{
+			name: "closest to noon",
+			now:  at(10, 19, 12, 30),
+			cfg:  cfg,
+			in:   []time.Time{at(8, 1, 6, 0), at(8, 1, 11, 30), at(8, 1, 12, 20), at(8, 1, 18, 0), at(8, 2, 23, 0)},
+			keep: []time.Time{at(8, 1, 12, 20), at(8, 2, 23, 0)},
+		},
+		{
+			name: "dst ends",
+			now:  at(10, 26, 12, 0),
+			cfg:  WebcamConfig{KeepAllHours: 24, HourlyDays: 30, DailyDays: 365},
+			// 02:00-03:00 happens twice on October 25th, CEST then CET. Names
+			// are wall clock, so it is one hour of the archive
+			in: []time.Time{
+				at(10, 25, 1, 30),
+				time.Date(2026, 10, 25, 0, 10, 0, 0, time.UTC), // 02:10 CEST
+				time.Date(2026, 10, 25, 0, 40, 0, 0, time.UTC), // 02:40 CEST
+				time.Date(2026, 10, 25, 1, 20, 0, 0, time.UTC), // 02:20 CET
+				time.Date(2026, 10, 25, 1, 50, 0, 0, time.UTC), // 02:50 CET
+				at(10, 25, 3, 10),
+			},
+			keep: []time.Time{
+				at(10, 25, 1, 30),
+				time.Date(2026, 10, 25, 0, 10, 0, 0, time.UTC),
+				at(10, 25, 3, 10),
+			},
+		},
+		{
+			name: "dst starts",
+			now:  at(10, 19, 12, 0),
+			cfg:  cfg,
+			// March 29th has no 02:00, noon is still local noon
+			in:   []time.Time{at(3, 29, 1, 0), at(3, 29, 3, 0), at(3, 29, 11, 0), at(3, 29, 13, 30)},
+			keep: []time.Time{at(3, 29, 11, 0)},
+		},
+		{
+			name: "no hourly tier",
+			now:  at(10, 19, 12, 30),
+			cfg:  WebcamConfig{KeepAllHours: 48, HourlyDays: 0, DailyDays: 365},
+			in:   []time.Time{at(10, 10, 10, 0), at(10, 10, 11, 0), at(10, 10, 12, 10)},
+			keep: []time.Time{at(10, 10, 12, 10)},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			for i := range c.in {
+				c.in[i] = c.in[i].In(loc)
+			}
+			snaps := snapshotsAt(c.in...)
+			keep := retainedSnapshots(snaps, c.cfg, c.now)
+			want := make(map[string]bool)
+			for _, tm := range c.keep {
+				want[tm.In(loc).Format(webcamTimeFormat)+".jpg"] = true
+			}
+			for _, s := range snaps {
+				if keep[s.Name] != want[s.Name] {
+					t.Errorf("%s (%s): kept = %v, want %v", s.Name, s.Time.Format("MST"), keep[s.Name], want[s.Name])
+				}
+			}
+		})
+	}
+}
+
+// Pruning every hour ends with one snapshot per hour and per day in their
+// tiers, and a further run at the same time keeps them all.
+func TestRetainedSnapshotsConverge(t *testing.T) {
+	loc := webcamTestLoc(t)
+	cfg := WebcamConfig{KeepAllHours: 48, HourlyDays: 7, DailyDays: 30}
+	start := time.Date(2026, 9, 1, 0, 0, 0, 0, loc)
+	end := start.AddDate(0, 0, 40)
+
+	var snaps []webcamSnapshot
+	next := start
+	for now := start; !now.After(end); now = now.Add(time.Hour) {
+		for ; !next.After(now); next = next.Add(10 * time.Minute) {
+			snaps = append(snaps, snapshotsAt(next)...)
+		}
+		keep := retainedSnapshots(snaps, cfg, now)
+		kept := snaps[:0]
+		for _, s := range snaps {
+			if keep[s.Name] {
+				kept = append(kept, s)
+			}
+		}
+		snaps = kept
+	}
+
+	if keep := retainedSnapshots(snaps, cfg, end); len(keep) != len(snaps) {
+		t.Errorf("another run drops %d of %d snapshots", len(snaps)-len(keep), len(snaps))
+	}
+	hours := make(map[string]int)
+	days := make(map[string]int)
+	for _, s := range snaps {
+		switch {
+		case !s.Time.Before(end.Add(-48 * time.Hour)):
+		case !s.Time.Before(end.AddDate(0, 0, -7)):
+			hours[s.Time.Format("2006-01-02 15")]++
+		default:
+			days[s.Time.Format(time.DateOnly)]++
+		}
+	}
+	for h, n := range hours {
+		if n != 1 {
+			t.Errorf("hour %s has %d snapshots", h, n)
+		}
+	}
+	if len(days) != 30-7 {
+		t.Errorf("daily tier covers %d days, want %d", len(days), 30-7)
+	}
+	for d, n := range days {
+		if n != 1 {
+			t.Errorf("day %s has %d snapshots", d, n)
+		}
+	}
+	if want := 48*6 + 1 + (7*24 - 48) + (30 - 7); len(snaps) != want {
+		t.Errorf("%d snapshots left, want %d", len(snaps), want)
+	}
+}
+
+func TestPruneWebcamArchive(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.Local)
+	dir := t.TempDir()
+	snaps := snapshotsAt(
+		now.AddDate(-2, 0, 0),
+		now.AddDate(0, 0, -3),
+		now.AddDate(0, 0, -3).Add(5*time.Minute),
+		now.Add(-time.Hour),
+	)
+	for _, name := range []string{"current.jpg", snaps[0].Name, snaps[1].Name, snaps[2].Name, snaps[3].Name} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := pruneWebcamArchive(WebcamConfig{KeepAllHours: 48, HourlyDays: 30, DailyDays: 365}, now, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("removed %d files, want 2", removed)
+	}
+	for name, want := range map[string]bool{
+		"current.jpg": true,
+		snaps[0].Name: false, // Past the daily tier
+		snaps[1].Name: true,
+		snaps[2].Name: false, // Second of its hour
+		snaps[3].Name: true,
+	} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != want {
+			t.Errorf("%s exists = %v, want %v", name, err == nil, want)
+		}
+	}
+}
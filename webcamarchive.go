@@ -0,0 +1,313 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/flosch/pongo2/v6"
+)
+
+// Webcam Archive Pages
+//
+// Each camera's archive can be browsed by day: <archive>/2026-01.html is a
+// calendar of the month linking to the days with frames, and
+// <archive>/2026-01-01.html shows the frames of that day. <archive> is
+// webcam/archive for the first camera and webcam/<id>/archive for the others.
+// Pages are built from the snapshot names, so they follow the retention
+// policy: a day whose frames were all pruned loses its page. They are left out
+// of the sitemap (and so of the offline precache), there are hundreds of them.
+// The build renders all of them, a webcam update only those it changed.
+
+var weekdaysIt = []string{"lun", "mar", "mer", "gio", "ven", "sab", "dom"}
+
+type RenderArchiveLink struct {
+	Title string
+	Url   string
+}
+
+type RenderArchiveDay struct {
+	Day    int    // 0 pads the first and last week
+	Url    string // Empty for days without frames
+	Thumb  string
+	Frames int
+}
+
+type RenderArchiveMonth struct {
+	Title    string
+	Weekdays []string
+	Weeks    [][]RenderArchiveDay // Monday first
+	Prev     *RenderArchiveLink   // Neighbouring months with frames
+	Next     *RenderArchiveLink
+	Months   []RenderArchiveLink // Every month with frames, newest first
+}
+
+type RenderArchiveFrame struct {
+	Time  string
+	Thumb string
+	Web   string
+}
+
+type RenderArchiveDayPage struct {
+	Title  string
+	Month  RenderArchiveLink
+	Frames []RenderArchiveFrame
+	Prev   *RenderArchiveLink // Neighbouring days with frames
+	Next   *RenderArchiveLink
+}
+
+// The snapshots (oldest first) grouped by month and day.
+type webcamArchiveMonth struct {
+	Key  string // 2006-01
+	Days []webcamArchiveDay
+}
+
+type webcamArchiveDay struct {
+	Key   string // 2006-01-02
+	Snaps []webcamSnapshot
+}
+
+func groupWebcamArchive(snaps []webcamSnapshot) []webcamArchiveMonth {
+	var months []webcamArchiveMonth
+	for _, s := range snaps {
+		month, day := s.Time.Format("2006-01"), s.Time.Format(time.DateOnly)
+		if len(months) == 0 || months[len(months)-1].Key != month {
+			months = append(months, webcamArchiveMonth{Key: month})
+		}
+		m := &months[len(months)-1]
+		if len(m.Days) == 0 || m.Days[len(m.Days)-1].Key != day {
+			m.Days = append(m.Days, webcamArchiveDay{Key: day})
+		}
+		d := &m.Days[len(m.Days)-1]
+		d.Snaps = append(d.Snaps, s)
+	}
+	return months
+}
+
+// webcamArchiveChange is what a webcam update changed in a camera's archive.
+type webcamArchiveChange struct {
+	Days   map[string]bool // Days that gained or lost frames, 2006-01-02
+	Months bool            // A month gained its first frame or lost its last, every month page lists them
+}
+
+// webcamArchiveChanges compares the snapshots of a camera before and after an
+// update.
+func webcamArchiveChanges(before, after []webcamSnapshot) *webcamArchiveChange {
+	change := &webcamArchiveChange{Days: make(map[string]bool)}
+	names := make(map[string]bool)
+	monthsBefore, monthsAfter := make(map[string]bool), make(map[string]bool)
+	for _, s := range before {
+		names[s.Name] = true
+		monthsBefore[s.Time.Format("2006-01")] = true
+	}
+	for _, s := range after {
+		if !names[s.Name] {
+			change.Days[s.Time.Format(time.DateOnly)] = true
+		}
+		delete(names, s.Name)
+		monthsAfter[s.Time.Format("2006-01")] = true
+	}
+	for _, s := range before {
+		if names[s.Name] {
+			change.Days[s.Time.Format(time.DateOnly)] = true
+		}
+	}
+	change.Months = len(monthsBefore) != len(monthsAfter)
+	for m := range monthsBefore {
+		change.Months = change.Months || !monthsAfter[m]
+	}
+	return change
+}
+
+// webcamArchiveAffected reports whether the page of keys[i] (sorted) needs
+// rendering for changed keys: a changed key from its previous neighbour to
+// its next one is its own page, a neighbour that may have just appeared, or a
+// page between them that went, all of which move its previous/next links.
+func webcamArchiveAffected(keys []string, i int, changed map[string]bool) bool {
+	lo, hi := "", "\uffff"
+	if i > 0 {
+		lo = keys[i-1]
+	}
+	if i < len(keys)-1 {
+		hi = keys[i+1]
+	}
+	for k := range changed {
+		if k >= lo && k <= hi {
+			return true
+		}
+	}
+	return false
+}
+
+// webcamArchiveUrl is the page of the newest month, empty without frames.
+func webcamArchiveUrl(site *SiteConfig, cam WebcamCamera) string {
+	snaps, _ := webcamSnapshots(cam.Dir(), site.Location)
+	if len(snaps) == 0 {
+		return ""
+	}
+	return cam.Archive + "/" + snaps[len(snaps)-1].Time.Format("2006-01") + ".html"
+}
+
+// renderWebcamArchive renders the archive pages of cam for one locale and
+// removes the pages of days and months that no longer have frames. With a
+// change, only the pages it affects are rendered; nil renders every page.
+func renderWebcamArchive(site *SiteConfig, cam WebcamCamera, locale string, baseUrl string, renderIndex RenderIndex, change *webcamArchiveChange) {
+	snaps, err := webcamSnapshots(cam.Dir(), site.Location)
+	if err != nil {
+		log.Printf("Error listing webcam snapshots: %v", err)
+		return
+	}
+	months := groupWebcamArchive(snaps)
+	outDir := filepath.Join("dist", strings.TrimPrefix(baseUrl, "/"), cam.Archive)
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		log.Panic(err)
+	}
+	monthTpl := pongo2.Must(pongo2.FromFile("templates/webcam_archive_month.html"))
+	dayTpl := pongo2.Must(pongo2.FromFile("templates/webcam_archive_day.html"))
+	camName := cam.Locale(locale).Name
+
+	monthLink := func(m webcamArchiveMonth) *RenderArchiveLink {
+		t, _ := time.ParseInLocation("2006-01", m.Key, site.Location)
+		return &RenderArchiveLink{Title: formatMonth(locale, t), Url: cam.Archive + "/" + m.Key + ".html"}
+	}
+	var allMonths []RenderArchiveLink
+	for i := len(months) - 1; i >= 0; i-- {
+		allMonths = append(allMonths, *monthLink(months[i]))
+	}
+
+	// Days across months, for the previous/next day links
+	var allDays []webcamArchiveDay
+	for _, m := range months {
+		allDays = append(allDays, m.Days...)
+	}
+
+	// The pages to render, and the pages that should exist
+	current := make(map[string]bool)
+	var monthKeys, dayKeys []string
+	for _, m := range months {
+		monthKeys = append(monthKeys, m.Key)
+		current[m.Key+".html"] = true
+	}
+	for _, d := range allDays {
+		dayKeys = append(dayKeys, d.Key)
+		current[d.Key+".html"] = true
+	}
+	changedMonths := make(map[string]bool)
+	if change != nil {
+		for day := range change.Days {
+			changedMonths[day[:len("2006-01")]] = true
+		}
+	}
+	renderMonth := func(i int) bool {
+		return change == nil || change.Months || webcamArchiveAffected(monthKeys, i, changedMonths)
+	}
+	renderDay := func(i int) bool {
+		return change == nil || webcamArchiveAffected(dayKeys, i, change.Days)
+	}
+	dayLink := func(d webcamArchiveDay) *RenderArchiveLink {
+		return &RenderArchiveLink{Title: formatDate(locale, d.Snaps[0].Time), Url: cam.Archive + "/" + d.Key + ".html"}
+	}
+
+	render := func(tpl *pongo2.Template, name string, title string, extra pongo2.Context) {
+		path := cam.Archive + "/" + name
+		ctx := pongo2.Context{
+			"locale":        locale,
+			"base_url":      baseUrl,
+			"alternate_url": computeAlternateUrl(locale, path),
+			"page_title":    camName + " — " + title,
+			"t":             renderIndex,
+			"camera_name":   camName,
+			"camera_page":   cam.Page,
+		}
+		ctx.Update(extra)
+		if err := renderToFile(tpl, ctx, filepath.Join(outDir, name)); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	for mi, m := range months {
+		if !renderMonth(mi) {
+			continue
+		}
+		page := RenderArchiveMonth{Title: monthLink(m).Title, Months: allMonths}
+		if locale == "it" {
+			page.Weekdays = weekdaysIt
+		} else {
+			for d := time.Monday; d < time.Monday+7; d++ {
+				page.Weekdays = append(page.Weekdays, time.Weekday(d % 7).String()[:3])
+			}
+		}
+		if mi > 0 {
+			page.Prev = monthLink(months[mi-1])
+		}
+		if mi < len(months)-1 {
+			page.Next = monthLink(months[mi+1])
+		}
+
+		first, _ := time.ParseInLocation("2006-01", m.Key, site.Location)
+		byDay := make(map[int]webcamArchiveDay)
+		for _, d := range m.Days {
+			byDay[d.Snaps[0].Time.Day()] = d
+		}
+		cells := make([]RenderArchiveDay, (int(first.Weekday())+6)%7)
+		for day := 1; day <= first.AddDate(0, 1, -1).Day(); day++ {
+			cell := RenderArchiveDay{Day: day}
+			if d, ok := byDay[day]; ok {
+				// The frame closest to noon stands for the day
+				best := d.Snaps[0]
+				for _, s := range d.Snaps {
+					if noonDistance(s.Time) < noonDistance(best.Time) {
+						best = s
+					}
+				}
+				cell.Url = cam.Archive + "/" + d.Key + ".html"
+				cell.Thumb = cam.Url() + "/" + webcamThumbDir + "/" + best.Name
+				cell.Frames = len(d.Snaps)
+			}
+			cells = append(cells, cell)
+		}
+		for len(cells)%7 != 0 {
+			cells = append(cells, RenderArchiveDay{})
+		}
+		for i := 0; i < len(cells); i += 7 {
+			page.Weeks = append(page.Weeks, cells[i:i+7])
+		}
+		render(monthTpl, m.Key+".html", page.Title, pongo2.Context{"archive": page})
+	}
+
+	dayIndex := 0
+	for _, m := range months {
+		for _, d := range m.Days {
+			if !renderDay(dayIndex) {
+				dayIndex++
+				continue
+			}
+			dp := RenderArchiveDayPage{Title: formatDate(locale, d.Snaps[0].Time), Month: *monthLink(m)}
+			for _, s := range d.Snaps {
+				dp.Frames = append(dp.Frames, RenderArchiveFrame{
+					Time:  formatTime(locale, s.Time),
+					Thumb: cam.Url() + "/" + webcamThumbDir + "/" + s.Name,
+					Web:   cam.Url() + "/" + webcamWebDir + "/" + s.Name,
+				})
+			}
+			if dayIndex > 0 {
+				dp.Prev = dayLink(allDays[dayIndex-1])
+			}
+			if dayIndex < len(allDays)-1 {
+				dp.Next = dayLink(allDays[dayIndex+1])
+			}
+			dayIndex++
+			render(dayTpl, d.Key+".html", dp.Title, pongo2.Context{"archive": dp})
+		}
+	}
+
+	// Days and months pruned since the last run
+	entries, _ := os.ReadDir(outDir)
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".html") && !current[entry.Name()] {
+			os.Remove(filepath.Join(outDir, entry.Name()))
+		}
+	}
+}
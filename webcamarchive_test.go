@@ -0,0 +1,130 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWebcamArchiveChanges(t *testing.T) {
+	loc := webcamTestLoc(t)
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2026, month, day, hour, 0, 0, 0, loc)
+	}
+	existing := []time.Time{at(9, 30, 12), at(10, 1, 12), at(10, 2, 12), at(10, 2, 13), at(10, 3, 12)}
+
+	for _, c := range []struct {
+		name   string
+		after  []time.Time
+		days   []string
+		months bool
+	}{
+		{
+			name:  "unchanged",
+			after: existing,
+		},
+		{
+			name:  "new frame on a day",
+			after: append(existing[:len(existing):len(existing)], at(10, 3, 13)),
+			days:  []string{"2026-10-03"},
+		},
+		{
+			name:  "new day",
+			after: append(existing[:len(existing):len(existing)], at(10, 4, 12)),
+			days:  []string{"2026-10-04"},
+		},
+		{
+			name:  "frame pruned",
+			after: []time.Time{at(9, 30, 12), at(10, 1, 12), at(10, 2, 12), at(10, 3, 12)},
+			days:  []string{"2026-10-02"},
+		},
+		{
+			name:  "day pruned between neighbours",
+			after: []time.Time{at(9, 30, 12), at(10, 2, 12), at(10, 2, 13), at(10, 3, 12)},
+			days:  []string{"2026-10-01"},
+		},
+		{
+			name:   "month loses its last frame",
+			after:  existing[1:],
+			days:   []string{"2026-09-30"},
+			months: true,
+		},
+		{
+			name:   "new month",
+			after:  append(existing[:len(existing):len(existing)], at(11, 1, 12)),
+			days:   []string{"2026-11-01"},
+			months: true,
+		},
+		{
+			name:   "one month in for another out",
+			after:  append(existing[1:len(existing):len(existing)], at(11, 1, 12)),
+			days:   []string{"2026-09-30", "2026-11-01"},
+			months: true,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			change := webcamArchiveChanges(snapshotsAt(existing...), snapshotsAt(c.after...))
+			want := make(map[string]bool)
+			for _, d := range c.days {
+				want[d] = true
+			}
+			if !reflect.DeepEqual(change.Days, want) {
+				t.Errorf("days = %v, want %v", change.Days, want)
+			}
+			if change.Months != c.months {
+				t.Errorf("months = %v, want %v", change.Months, c.months)
+			}
+		})
+	}
+}
+
+func TestWebcamArchiveAffected(t *testing.T) {
+	keys := []string{"2026-09-30", "2026-10-02", "2026-10-03", "2026-10-05", "2026-10-06"}
+
+	for _, c := range []struct {
+		name    string
+		changed []string
+		want    []bool
+	}{
+		{
+			name: "nothing changed",
+			want: []bool{false, false, false, false, false},
+		},
+		{
+			// The page itself, and the neighbours linking to it
+			name:    "frames of a day",
+			changed: []string{"2026-10-03"},
+			want:    []bool{false, true, true, true, false},
+		},
+		{
+			// The newest day: its previous page gains a next link
+			name:    "new day",
+			changed: []string{"2026-10-06"},
+			want:    []bool{false, false, false, true, true},
+		},
+		{
+			// 10-01 went, 09-30 and 10-02 now link to each other
+			name:    "day pruned between neighbours",
+			changed: []string{"2026-10-01"},
+			want:    []bool{true, true, false, false, false},
+		},
+		{
+			// The oldest day went, the new oldest loses its previous link
+			name:    "oldest day pruned",
+			changed: []string{"2026-09-29"},
+			want:    []bool{true, false, false, false, false},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			changed := make(map[string]bool)
+			for _, k := range c.changed {
+				changed[k] = true
+			}
+			for i, want := range c.want {
+				if got := webcamArchiveAffected(keys, i, changed); got != want {
+					t.Errorf("%s affected = %v, want %v", keys[i], got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Webcam Daemon
+//
+// -webcam-daemon keeps running next to the cameras: frames dropped into
+// [webcam] incoming_dir/<id>/, or POSTed to /upload/<id>, go through the
+// same pipeline as -update-webcam. Events are debounced, so a frame is only
+// read once its writer is done and a burst of frames re-renders the pages
+// once. Uploads are written to a hidden temp file and renamed into the
+// incoming dir, the watcher never sees them half-written. Weather station
+// readings POSTed to /weather are added like -update-weather.
+
+// The upload secrets, kept out of site.toml (committed). The weather station
+// gets its own, /weather is only served when it is set.
+const (
+	webcamTokenEnv  = "BRUGGI_WEBCAM_TOKEN"
+	weatherTokenEnv = "BRUGGI_WEATHER_TOKEN"
+)
+
+const webcamMaxUpload = 20 << 20
+
+// The upload server faces the internet: slow or stalled clients are cut off
+// rather than holding connections forever. ReadTimeout covers a full frame
+// on a slow uplink.
+const (
+	webcamReadHeaderTimeout = 10 * time.Second
+	webcamReadTimeout       = 2 * time.Minute
+	webcamWriteTimeout      = 2 * time.Minute
+	webcamIdleTimeout       = 2 * time.Minute
+)
+
+func runWebcamDaemon() {
+	site, err := loadSite("content/site.toml")
+	if err != nil {
+		log.Fatalf("Error loading site config: %v", err)
+	}
+	incoming := site.Webcam.IncomingDir
+	if incoming == "" {
+		log.Fatal("Error: [webcam] incoming_dir is not set in site.toml")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer watcher.Close()
+
+	// One incoming dir per camera, frames are matched to cameras by dir
+	cams := make(map[string]WebcamCamera)
+	for _, cam := range site.Webcams {
+		dir := filepath.Join(incoming, cam.ID)
+		if err := os.MkdirAll(filepath.Join(dir, "failed"), 0755); err != nil {
+			log.Fatalf("Error creating incoming dir: %v", err)
+		}
+		if err := watcher.Add(dir); err != nil {
+			log.Fatalf("Error watching %s: %v", dir, err)
+		}
+		cams[dir] = cam
+	}
+
+	if site.Webcam.Listen != "" {
+		token := os.Getenv(webcamTokenEnv)
+		if token == "" {
+			log.Fatalf("Error: %s must be set to accept uploads on %s", webcamTokenEnv, site.Webcam.Listen)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/upload/{camera}", webcamUploadHandler(incoming, cams, token))
+		if weatherToken := os.Getenv(weatherTokenEnv); weatherToken != "" {
+			mux.Handle("/weather", weatherUploadHandler(site, weatherToken))
+			log.Printf("Weather readings on http://%s/weather", site.Webcam.Listen)
+		} else {
+			log.Printf("%s is not set, /weather is disabled", weatherTokenEnv)
+		}
+		server := &http.Server{
+			Addr:              site.Webcam.Listen,
+			Handler:           mux,
+			ReadHeaderTimeout: webcamReadHeaderTimeout,
+			ReadTimeout:       webcamReadTimeout,
+			WriteTimeout:      webcamWriteTimeout,
+			IdleTimeout:       webcamIdleTimeout,
+		}
+		go func() {
+			log.Fatal(server.ListenAndServe())
+		}()
+		log.Printf("Webcam uploads on http://%s/upload/<camera>", site.Webcam.Listen)
+	}
+
+	debounce := time.Duration(site.Webcam.DebounceSeconds * float64(time.Second))
+	timer := time.NewTimer(debounce)
+	pending := make(map[string]bool)
+
+	// Frames left over from before a restart
+	for dir := range cams {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if isWebcamFrameName(entry.Name()) && !entry.IsDir() {
+				pending[filepath.Join(dir, entry.Name())] = true
+			}
+		}
+	}
+
+	log.Printf("Webcam daemon watching %s", incoming)
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Create|fsnotify.Write) != 0 && isWebcamFrameName(filepath.Base(event.Name)) {
+				pending[event.Name] = true
+				timer.Reset(debounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Println("error:", err)
+		case <-timer.C:
+			// A batch per camera
+			batches := make(map[string]map[string]bool)
+			for path := range pending {
+				dir := filepath.Dir(path)
+				if batches[dir] == nil {
+					batches[dir] = make(map[string]bool)
+				}
+				batches[dir][path] = true
+			}
+			for dir, batch := range batches {
+				processWebcamFrames(site, cams[dir], batch, filepath.Join(dir, "failed"))
+			}
+			pending = make(map[string]bool)
+		}
+	}
+}
+
+// isWebcamFrameName skips hidden files (uploads in progress, editor swap
+// files) and anything that is not a JPEG.
+func isWebcamFrameName(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return !strings.HasPrefix(name, ".") && (ext == ".jpg" || ext == ".jpeg")
+}
+
+// processWebcamFrames ingests a debounced batch. Frames are timestamped with
+// their modification time. Bad frames end up in the quarantine dir, the
+// failed dir is for batches that could not be ingested at all.
+func processWebcamFrames(site *SiteConfig, cam WebcamCamera, pending map[string]bool, failed string) {
+	var frames []webcamFrame
+	for path := range pending {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue // Already processed or moved away
+		}
+		frames = append(frames, webcamFrame{Path: path, Time: info.ModTime()})
+	}
+	if len(frames) == 0 {
+		return
+	}
+	sort.Slice(frames, func(i, j int) bool { return frames[i].Time.Before(frames[j].Time) })
+
+	accepted, err := ingestWebcamFrames(site, cam, frames)
+	if err != nil {
+		log.Printf("Error ingesting webcam frames: %v", err)
+		for _, f := range frames {
+			moveToDir(f.Path, failed)
+		}
+		return
+	}
+	for _, f := range frames {
+		if err := os.Remove(f.Path); err != nil {
+			log.Printf("Error removing %s: %v", f.Path, err)
+		}
+	}
+	log.Printf("Webcam %s: ingested %d of %d frame(s), latest %s", cam.ID, accepted, len(frames), frames[len(frames)-1].Time.Format(time.DateTime))
+}
+
+func checkJPEG(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = jpeg.DecodeConfig(f)
+	return err
+}
+
+func moveToDir(path string, dir string) {
+	if err := os.Rename(path, filepath.Join(dir, filepath.Base(path))); err != nil {
+		log.Printf("Error moving %s to %s: %v", path, dir, err)
+	}
+}
+
+// webcamUploadHandler accepts a raw JPEG body (curl --data-binary @frame.jpg)
+// with an "Authorization: Bearer <token>" header, for the camera named in the
+// path. cams maps incoming dirs to cameras.
+func webcamUploadHandler(incoming string, cams map[string]WebcamCamera, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		dir := filepath.Join(incoming, r.PathValue("camera"))
+		if _, ok := cams[dir]; !ok {
+			http.Error(w, "unknown camera", http.StatusNotFound)
+			return
+		}
+
+		tmp, err := os.CreateTemp(dir, ".upload-*.jpg")
+		if err != nil {
+			log.Printf("Error creating upload file: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(tmp.Name()) // No-op once renamed
+
+		_, err = io.Copy(tmp, http.MaxBytesReader(w, r.Body, webcamMaxUpload))
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			http.Error(w, "upload failed: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := checkJPEG(tmp.Name()); err != nil {
+			http.Error(w, "not a JPEG image", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		name := fmt.Sprintf("upload-%s.jpg", time.Now().Format("20060102-150405.000000000"))
+		if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+			log.Printf("Error storing upload: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Webcam Derivatives
+//
+// The archive keeps each frame as the camera sent it. The site shows resized
+// copies instead, with the capture time and the watermark burned in: web/
+// for the panorama and the time-lapses, thumb/ for the frame strip. They use
+// the snapshot's name, so retention prunes them like the originals.
+
+const (
+	webcamWebDir   = "web"
+	webcamThumbDir = "thumb"
+)
+
+// writeWebcamDerivatives writes the web and thumbnail versions of the frame
+// at src, named name, into the web/ and thumb/ dirs of each root.
+func writeWebcamDerivatives(cfg WebcamConfig, src string, name string, t time.Time, roots ...string) error {
+	img, err := imaging.Open(src)
+	if err != nil {
+		return err
+	}
+	for _, d := range []struct {
+		dir   string
+		width int
+	}{{webcamWebDir, cfg.WebWidth}, {webcamThumbDir, cfg.ThumbWidth}} {
+		out := imaging.Clone(img)
+		if img.Bounds().Dx() > d.width {
+			out = imaging.Resize(img, d.width, 0, imaging.Lanczos)
+		}
+		stampWebcamFrame(out, t, cfg.Watermark)
+		for _, root := range roots {
+			dir := filepath.Join(root, d.dir)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+			if err := saveImage(out, filepath.Join(dir, name), imaging.JPEGQuality(85)); err != nil {
+				return fmt.Errorf("saving %s/%s: %w", d.dir, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// stampWebcamFrame draws the capture time in the bottom left corner and the
+// watermark in the bottom right one, on translucent boxes so they stay
+// readable on snow as well as at dusk.
+func stampWebcamFrame(img *image.NRGBA, t time.Time, watermark string) {
+	f, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		return
+	}
+	b := img.Bounds()
+	size := max(9, float64(b.Dx())/48)
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return
+	}
+	defer face.Close()
+
+	pad := int(size / 2)
+	boxH := int(size) + pad*2
+	label := func(x int, text string) {
+		w := font.MeasureString(face, text).Ceil()
+		if x < 0 {
+			x = b.Max.X - w - pad*3 // Right-aligned
+		}
+		box := image.Rect(x, b.Max.Y-boxH-pad, x+w+pad*2, b.Max.Y-pad)
+		draw.Draw(img, box, image.NewUniform(color.NRGBA{0, 0, 0, 0x80}), image.Point{}, draw.Over)
+		d := font.Drawer{Dst: img, Src: image.NewUniform(color.NRGBA{0xff, 0xff, 0xff, 0xe6}), Face: face, Dot: fixed.P(box.Min.X+pad, box.Max.Y-pad-int(size)/8)}
+		d.DrawString(text)
+	}
+	label(b.Min.X+pad, t.Format("2006-01-02 15:04"))
+	if watermark != "" {
+		label(-1, watermark)
+	}
+}
+
+// backfillWebcamDerivatives makes the derivatives that are missing or older
+// than their original in a camera's static dir (frames archived before
+// derivatives existed, or added by hand) and removes the ones whose original
+// is gone.
+func backfillWebcamDerivatives(site *SiteConfig, cam WebcamCamera) {
+	cfg := site.Webcam.For(cam)
+	dir := cam.Dir()
+	snaps, err := webcamSnapshots(dir, site.Location)
+	if err != nil {
+		log.Printf("Error listing webcam snapshots: %v", err)
+		return
+	}
+	// current.jpg has no time in its name, it is the newest snapshot
+	frames := make(map[string]time.Time)
+	for _, s := range snaps {
+		frames[s.Name] = s.Time
+	}
+	if info, err := os.Stat(filepath.Join(dir, "current.jpg")); err == nil {
+		frames["current.jpg"] = info.ModTime().In(site.Location)
+		if len(snaps) > 0 {
+			frames["current.jpg"] = snaps[len(snaps)-1].Time
+		}
+	}
+
+	made := 0
+	for name, t := range frames {
+		if webcamDerivativesFresh(dir, name) {
+			continue
+		}
+		if err := writeWebcamDerivatives(cfg, filepath.Join(dir, name), name, t, dir); err != nil {
+			log.Printf("Warning: webcam derivatives of %s: %v", name, err)
+			continue
+		}
+		made++
+	}
+	if made > 0 {
+		log.Printf("Webcam %s: made derivatives of %d frame(s)", cam.ID, made)
+	}
+
+	for _, sub := range []string{webcamWebDir, webcamThumbDir} {
+		entries, _ := os.ReadDir(filepath.Join(dir, sub))
+		for _, entry := range entries {
+			if _, ok := frames[entry.Name()]; !ok && !entry.IsDir() {
+				os.Remove(filepath.Join(dir, sub, entry.Name()))
+			}
+		}
+	}
+}
+
+func webcamDerivativesFresh(dir string, name string) bool {
+	orig, err := os.Stat(filepath.Join(dir, name))
+	if err != nil {
+		return false
+	}
+	for _, sub := range []string{webcamWebDir, webcamThumbDir} {
+		info, err := os.Stat(filepath.Join(dir, sub, name))
+		if err != nil || info.ModTime().Before(orig.ModTime()) {
+			return false
+		}
+	}
+	return true
+}